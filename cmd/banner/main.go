@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"github.com/mashmorsik/banners-service/config"
 	"github.com/mashmorsik/banners-service/infrastructure/data"
 	"github.com/mashmorsik/banners-service/infrastructure/data/cache"
@@ -40,14 +41,41 @@ func main() {
 	dat := data.NewData(ctx, conn)
 
 	bannerCache := cache.NewBannerCache(ctx, conf.Cache.EvictionWorkerDuration, conf)
+	if conf.Cache.Invalidation.Enabled {
+		go cache.NewInvalidationListener(ctx, conf, bannerCache).Listen()
+	}
 
 	bannerRepo := repository.NewBannerRepo(ctx, dat)
-	bb := banner.NewBanner(ctx, bannerRepo, conf, &bannerCache)
+	bb := banner.NewBanner(ctx, bannerRepo, conf, bannerCache)
+	go bb.RetentionWorker()
+	go bb.PurgeWorker()
+	go bb.Events.Run()
+
+	if failed, err := bb.Jobs.FailStale(); err != nil {
+		logger.Errf("fail to mark stale jobs failed: %s", err)
+	} else if failed > 0 {
+		logger.Infof("failed %d jobs left unfinished by stopped instances", failed)
+	}
+
+	if conf.Cache.WarmUp.Enabled {
+		warmed, err := bb.WarmUp()
+		if err != nil {
+			logger.Warn(fmt.Sprintf("cache warm-up failed, starting cold: %s", err))
+		} else {
+			logger.Infof("cache warmed up with %d banners", warmed)
+		}
+	}
 
 	token.NewTokenManager(conf.Auth.TokenSecret)
 
-	httpServer := server.NewServer(conf, *bb)
+	httpServer := server.NewServer(conf, bb)
 	if err = httpServer.StartServer(ctx); err != nil {
 		logger.Warn(err.Error())
 	}
+
+	bb.Jobs.Wait()
+
+	if err = bb.Events.Flush(); err != nil {
+		logger.Errf("fail to flush banner events on shutdown: %s", err)
+	}
 }
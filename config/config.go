@@ -12,15 +12,58 @@ type Config struct {
 		Port string `yaml:"port"`
 	} `yaml:"postgres"`
 	Server struct {
-		Port string `yaml:"port"`
+		Port           string `yaml:"port"`
+		RequireIfMatch bool   `yaml:"requireIfMatch"`
 	} `yaml:"server"`
 	Cache struct {
 		EvictionWorkerDuration time.Duration `yaml:"evictionWorkerDuration"`
-		BannerExpiration       time.Duration `yaml:"bannerExpiration"`
+		SoftTTL                time.Duration `yaml:"softTTL"`
+		HardTTL                time.Duration `yaml:"hardTTL"`
+		MaxStaleness           time.Duration `yaml:"maxStaleness"`
+		NegativeTTL            time.Duration `yaml:"negativeTTL"`
+		Shards                 int           `yaml:"shards"`
+		MaxEntries             int           `yaml:"maxEntries"`
+		MaxBytes               int64         `yaml:"maxBytes"`
+		Invalidation           struct {
+			Enabled              bool          `yaml:"enabled"`
+			MinReconnectInterval time.Duration `yaml:"minReconnectInterval"`
+			MaxReconnectInterval time.Duration `yaml:"maxReconnectInterval"`
+			PingInterval         time.Duration `yaml:"pingInterval"`
+		} `yaml:"invalidation"`
+		WarmUp struct {
+			Enabled bool          `yaml:"enabled"`
+			Timeout time.Duration `yaml:"timeout"`
+		} `yaml:"warmUp"`
 	} `yaml:"cache"`
 	Auth struct {
 		TokenSecret string `yaml:"tokenSecret"`
 	} `yaml:"auth"`
+	Retention struct {
+		KeepLastVersions int           `yaml:"keepLastVersions"`
+		MaxVersionAge    time.Duration `yaml:"maxVersionAge"`
+		WorkerDuration   time.Duration `yaml:"workerDuration"`
+	} `yaml:"retention"`
+	Trash struct {
+		GracePeriod         time.Duration `yaml:"gracePeriod"`
+		PurgeWorkerDuration time.Duration `yaml:"purgeWorkerDuration"`
+	} `yaml:"trash"`
+	Catalog struct {
+		// Strict rejects banners referring to features and tags that are missing in the
+		// catalog or archived, otherwise missing ones are added with an empty name.
+		Strict bool `yaml:"strict"`
+	} `yaml:"catalog"`
+	Events struct {
+		// FlushInterval is how often buffered event counts are written to the database.
+		FlushInterval time.Duration `yaml:"flushInterval"`
+		FlushTimeout  time.Duration `yaml:"flushTimeout"`
+		// MaxPending limits the counters kept in memory, events that need a new counter
+		// are dropped while the buffer is full.
+		MaxPending int `yaml:"maxPending"`
+	} `yaml:"events"`
+	Jobs struct {
+		TTL                 time.Duration `yaml:"ttl"`
+		BulkDeleteBatchSize int           `yaml:"bulkDeleteBatchSize"`
+	} `yaml:"jobs"`
 }
 
 func LoadConfig() (*Config, error) {
@@ -15,6 +15,7 @@ import (
 	"github.com/mashmorsik/logger"
 	"io"
 	"net/http"
+	"reflect"
 	"testing"
 	"time"
 )
@@ -41,7 +42,7 @@ func Test_GetUserBanner(t *testing.T) {
 	bannerCache := cache.NewBannerCache(ctx, conf.Cache.EvictionWorkerDuration, conf)
 
 	bannerRepo := repository.NewBannerRepo(ctx, dat)
-	_ = banner.NewBanner(ctx, bannerRepo, conf, &bannerCache)
+	_ = banner.NewBanner(ctx, bannerRepo, conf, bannerCache)
 
 	token.NewTokenManager(conf.Auth.TokenSecret)
 
@@ -67,9 +68,9 @@ func Test_GetUserBanner(t *testing.T) {
 		FeatureID: 70,
 		IsActive:  true,
 		Content: models.Content{
-			Title: "New_super_banner",
-			Text:  "Super_description",
-			URL:   "Mega_super_URL",
+			"title": "New_super_banner",
+			"text":  "Super_description",
+			"url":   "https://example.com/mega_super_banner",
 		},
 	}
 
@@ -135,7 +136,7 @@ func Test_GetUserBanner(t *testing.T) {
 	}
 
 	// compare the content
-	if newBanner.Content != *userBanner {
+	if !reflect.DeepEqual(newBanner.Content, *userBanner) {
 		t.Errorf("GetUserBanner failed: expected %v, got %v", newBanner.Content, userBanner)
 	}
 
@@ -1,87 +1,437 @@
 package cache
 
 import (
+	"container/list"
 	"context"
+	"encoding/json"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/mashmorsik/banners-service/config"
 	"github.com/mashmorsik/banners-service/pkg/models"
-	"github.com/mashmorsik/logger"
 )
 
-var cache sync.Map
+const (
+	defaultShards     = 16
+	defaultMaxEntries = 10000
+	defaultHardTTL    = 5 * time.Minute
+	defaultSoftTTL    = time.Minute
+	// entryOverhead approximates the memory taken by an entry besides its content strings.
+	entryOverhead = 128
+)
+
+// Key identifies the cached banner of a feature and tag pair. The banner is cached with all
+// of its locales, so the locales a client asks for can't multiply the entries of a pair.
+type Key struct {
+	FeatureID int
+	TagID     int
+}
+
+func NewKey(featureID, tagID int) Key {
+	return Key{FeatureID: featureID, TagID: tagID}
+}
+
+// Freshness tells how a cached entry may be served.
+type Freshness int
+
+const (
+	// Fresh entries are served as is.
+	Fresh Freshness = iota
+	// Stale entries are past the soft TTL, they are served while being refreshed.
+	Stale
+	// Expired entries are past the hard TTL, they are served only when the database is unavailable.
+	Expired
+)
 
+// Stats is a snapshot of the cache counters.
+type Stats struct {
+	Hits        uint64 `json:"hits"`
+	Misses      uint64 `json:"misses"`
+	Evictions   uint64 `json:"evictions"`
+	Expirations uint64 `json:"expirations"`
+	Entries     int    `json:"entries"`
+	Bytes       int64  `json:"bytes"`
+}
+
+// BannerCache is a sharded LRU bounded by the number of entries and their approximate size.
+// Entries past the max staleness are dropped on access and by the eviction worker, one
+// shard per tick.
 type BannerCache struct {
 	Ctx                    context.Context
 	evictionWorkerDuration time.Duration
 	Config                 *config.Config
+	shards                 []*shard
+	hardTTL                time.Duration
+	softTTL                time.Duration
+
+	hits        atomic.Uint64
+	misses      atomic.Uint64
+	evictions   atomic.Uint64
+	expirations atomic.Uint64
 }
 
-func NewBannerCache(ctx context.Context, evictionWorkerDuration time.Duration, conf *config.Config) BannerCache {
-	bc := BannerCache{Ctx: ctx, evictionWorkerDuration: evictionWorkerDuration, Config: conf}
-	go bc.evictionWorker()
+func NewBannerCache(ctx context.Context, evictionWorkerDuration time.Duration, conf *config.Config) *BannerCache {
+	shardsCount := conf.Cache.Shards
+	if shardsCount <= 0 {
+		shardsCount = defaultShards
+	}
+
+	maxEntries := conf.Cache.MaxEntries
+	if maxEntries <= 0 {
+		maxEntries = defaultMaxEntries
+	}
+
+	hardTTL := conf.Cache.HardTTL
+	if hardTTL <= 0 {
+		hardTTL = defaultHardTTL
+	}
+
+	softTTL := conf.Cache.SoftTTL
+	if softTTL <= 0 {
+		softTTL = defaultSoftTTL
+	}
+
+	bc := &BannerCache{
+		Ctx:                    ctx,
+		evictionWorkerDuration: evictionWorkerDuration,
+		Config:                 conf,
+		shards:                 make([]*shard, shardsCount),
+		hardTTL:                hardTTL,
+		softTTL:                softTTL,
+	}
+	for i := range bc.shards {
+		bc.shards[i] = &shard{
+			maxEntries: max(maxEntries/shardsCount, 1),
+			maxBytes:   conf.Cache.MaxBytes / int64(shardsCount),
+			items:      make(map[Key]*list.Element),
+			lru:        list.New(),
+		}
+	}
+
+	if evictionWorkerDuration > 0 {
+		go bc.evictionWorker()
+	}
 	return bc
 }
 
+// Entry is the cached content of a pair with its locales, the variants of its A/B test when
+// it has any and the banner version it comes from.
+type Entry struct {
+	BannerID int
+	Version  int
+	Content  models.Content
+	Locales  map[string]models.Content
+	Variants []models.Variant
+}
+
+// Localized returns the entry served to users of the locales, the content and the variants
+// are in the first of the locales the banner has.
+func (e *Entry) Localized(locales []string) *Entry {
+	banner := &models.Banner{Content: e.Content, Locales: e.Locales, Variants: e.Variants}
+
+	return &Entry{
+		BannerID: e.BannerID,
+		Version:  e.Version,
+		Content:  banner.LocalizedContent(locales),
+		Variants: banner.LocalizedVariants(locales),
+	}
+}
+
 type Item struct {
-	BannerContent models.Content
-	Eviction      time.Time
+	Key Key
+	Entry
+	// NotFound marks a feature/tag pair known to have no banner.
+	NotFound   bool
+	SoftExpiry time.Time
+	HardExpiry time.Time
+	// Eviction is the hard expiry extended by the max staleness, the entry is dropped after it.
+	Eviction time.Time
+	size     int64
 }
 
-func (b *BannerCache) Set(key string, bannerContent models.Content) {
-	cache.Store(key, Item{
-		BannerContent: bannerContent,
-		Eviction:      time.Now().Add(b.Config.Cache.BannerExpiration),
-	})
+func (i *Item) freshness(now time.Time) Freshness {
+	switch {
+	case now.Before(i.SoftExpiry):
+		return Fresh
+	case now.Before(i.HardExpiry):
+		return Stale
+	default:
+		return Expired
+	}
 }
 
-func (b *BannerCache) Get(key string) (*models.Content, bool) {
-	foundItem, ok := cache.Load(key)
-	if !ok {
-		return nil, false
+func (b *BannerCache) Set(key Key, bannerContent models.Content) {
+	b.SetEntry(key, Entry{Content: bannerContent}, time.Time{})
+}
+
+func (b *BannerCache) SetUntil(key Key, bannerContent models.Content, until time.Time) {
+	b.SetEntry(key, Entry{Content: bannerContent}, until)
+}
+
+// SetEntry stores the entry with the configured TTLs, none of them is allowed to pass
+// the given time, so content of a banner is never served after the end of its schedule.
+// A zero time stands for a banner shown until the end of time. A soft TTL that is not
+// shorter than the hard TTL disables background refresh.
+func (b *BannerCache) SetEntry(key Key, entry Entry, until time.Time) {
+	now := time.Now()
+	if until.IsZero() {
+		until = now.Add(b.hardTTL + b.Config.Cache.MaxStaleness)
+	}
+	hardExpiry := now.Add(b.hardTTL)
+	softExpiry := hardExpiry
+	if b.softTTL < b.hardTTL {
+		softExpiry = now.Add(b.softTTL)
 	}
 
-	item, ok := b.isInvalidType(foundItem)
-	if !ok {
+	item := &Item{
+		Key:        key,
+		Entry:      entry,
+		SoftExpiry: earliest(softExpiry, until),
+		HardExpiry: earliest(hardExpiry, until),
+		Eviction:   earliest(hardExpiry.Add(max(b.Config.Cache.MaxStaleness, 0)), until),
+		size:       contentSize(entry),
+	}
+
+	evicted := b.shard(key).set(item)
+	b.evictions.Add(uint64(evicted))
+}
+
+// SetNotFound remembers that the pair has no banner for the negative TTL, a zero TTL
+// disables negative caching.
+func (b *BannerCache) SetNotFound(key Key) {
+	if b.Config.Cache.NegativeTTL <= 0 {
+		return
+	}
+
+	expiry := time.Now().Add(b.Config.Cache.NegativeTTL)
+	item := &Item{
+		Key:        key,
+		NotFound:   true,
+		SoftExpiry: expiry,
+		HardExpiry: expiry,
+		Eviction:   expiry,
+		size:       entryOverhead,
+	}
+
+	evicted := b.shard(key).set(item)
+	b.evictions.Add(uint64(evicted))
+}
+
+// Get returns the content unless it is past the hard TTL or the pair is cached as not found.
+func (b *BannerCache) Get(key Key) (*models.Content, bool) {
+	entry, freshness, ok := b.Lookup(key)
+	if !ok || entry == nil || freshness == Expired {
 		return nil, false
 	}
 
-	return &item.BannerContent, true
+	return &entry.Content, true
+}
+
+// Lookup returns the entry kept past the hard TTL too, the caller decides whether
+// its freshness is good enough. Expired entries are counted as misses. A found nil
+// entry means the pair is cached as not found. The content is shared with the cache
+// and must not be modified.
+func (b *BannerCache) Lookup(key Key) (*Entry, Freshness, bool) {
+	now := time.Now()
+	item, dropped := b.shard(key).get(key, now)
+	if dropped {
+		b.expirations.Add(1)
+	}
+	if item == nil {
+		b.misses.Add(1)
+		return nil, Expired, false
+	}
+
+	freshness := item.freshness(now)
+	if freshness == Expired {
+		b.misses.Add(1)
+	} else {
+		b.hits.Add(1)
+	}
+
+	if item.NotFound {
+		return nil, freshness, true
+	}
+
+	entry := item.Entry
+	return &entry, freshness, true
+}
+
+// Delete drops the content of the key pair.
+func (b *BannerCache) Delete(key Key) {
+	b.shard(key).delete(key)
+}
+
+// Flush drops every cached banner.
+func (b *BannerCache) Flush() {
+	for _, s := range b.shards {
+		s.flush()
+	}
+}
+
+func (b *BannerCache) Stats() Stats {
+	stats := Stats{
+		Hits:        b.hits.Load(),
+		Misses:      b.misses.Load(),
+		Evictions:   b.evictions.Load(),
+		Expirations: b.expirations.Load(),
+	}
+	for _, s := range b.shards {
+		entries, bytes := s.size()
+		stats.Entries += entries
+		stats.Bytes += bytes
+	}
+
+	return stats
+}
+
+func (b *BannerCache) shard(key Key) *shard {
+	h := uint64(uint32(key.FeatureID))<<32 | uint64(uint32(key.TagID))
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+
+	return b.shards[h%uint64(len(b.shards))]
 }
 
 func (b *BannerCache) evictionWorker() {
 	ticker := time.NewTicker(b.evictionWorkerDuration)
+	defer ticker.Stop()
+
+	var next int
 	for {
 		select {
 		case <-b.Ctx.Done():
 			return
 		case <-ticker.C:
-			cache.Range(func(key any, value any) bool {
-				item, ok := b.isInvalidType(value)
-				if !ok {
-					return true
-				}
-
-				if item.Eviction.Before(time.Now()) {
-					cache.Delete(key)
-				}
-
-				return true
-			})
+			expired := b.shards[next].removeExpired(time.Now())
+			b.expirations.Add(uint64(expired))
+			next = (next + 1) % len(b.shards)
 		}
 	}
 }
 
-func (b *BannerCache) isInvalidType(foundItem any) (item *Item, invalid bool) {
-	var cacheItem Item
-	switch foundItem.(type) {
-	case Item:
-		cacheItem = foundItem.(Item)
-	default:
-		logger.Errf("invalid cache item %#+v", foundItem)
+func earliest(a, b time.Time) time.Time {
+	if b.Before(a) {
+		return b
+	}
+	return a
+}
+
+// contentSize approximates the memory taken by the content, the locales and the variants
+// with the length of their JSON.
+func contentSize(entry Entry) int64 {
+	size := int64(entryOverhead)
+	if data, err := json.Marshal(entry.Content); err == nil {
+		size += int64(len(data))
+	}
+	if len(entry.Locales) > 0 {
+		if data, err := json.Marshal(entry.Locales); err == nil {
+			size += int64(len(data))
+		}
+	}
+	if len(entry.Variants) > 0 {
+		if data, err := json.Marshal(entry.Variants); err == nil {
+			size += int64(len(data))
+		}
+	}
+
+	return size
+}
+
+// shard is a LRU list guarded by its own mutex, the front element is the most recently used.
+type shard struct {
+	mu         sync.Mutex
+	maxEntries int
+	maxBytes   int64
+	bytes      int64
+	items      map[Key]*list.Element
+	lru        *list.List
+}
+
+func (s *shard) get(key Key, now time.Time) (item *Item, expired bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	el, ok := s.items[key]
+	if !ok {
 		return nil, false
 	}
 
-	return &cacheItem, true
+	item = el.Value.(*Item)
+	if item.Eviction.Before(now) {
+		s.remove(el)
+		return nil, true
+	}
+
+	s.lru.MoveToFront(el)
+	return item, false
+}
+
+func (s *shard) set(item *Item) (evicted int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if el, ok := s.items[item.Key]; ok {
+		s.remove(el)
+	}
+
+	s.items[item.Key] = s.lru.PushFront(item)
+	s.bytes += item.size
+
+	for s.lru.Len() > 1 && (s.lru.Len() > s.maxEntries || (s.maxBytes > 0 && s.bytes > s.maxBytes)) {
+		s.remove(s.lru.Back())
+		evicted++
+	}
+
+	return evicted
+}
+
+func (s *shard) delete(key Key) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if el, ok := s.items[key]; ok {
+		s.remove(el)
+	}
+}
+
+func (s *shard) flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.items = make(map[Key]*list.Element)
+	s.lru.Init()
+	s.bytes = 0
+}
+
+func (s *shard) removeExpired(now time.Time) (expired int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for el := s.lru.Front(); el != nil; {
+		next := el.Next()
+		if el.Value.(*Item).Eviction.Before(now) {
+			s.remove(el)
+			expired++
+		}
+		el = next
+	}
+
+	return expired
+}
+
+func (s *shard) size() (entries int, bytes int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.lru.Len(), s.bytes
+}
+
+// remove must be called with the shard mutex held.
+func (s *shard) remove(el *list.Element) {
+	item := s.lru.Remove(el).(*Item)
+	delete(s.items, item.Key)
+	s.bytes -= item.size
 }
@@ -0,0 +1,166 @@
+package cache
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mashmorsik/banners-service/config"
+	"github.com/mashmorsik/banners-service/pkg/models"
+)
+
+func TestBannerCache(t *testing.T) {
+	tests := []struct {
+		name      string
+		conf      func(conf *config.Config)
+		set       func(bc *BannerCache)
+		cached    []Key
+		notCached []Key
+		want      Stats
+	}{
+		{
+			name: "keys_do_not_collide",
+			set: func(bc *BannerCache) {
+				bc.Set(NewKey(1, 23), models.Content{"title": "1_23"})
+			},
+			cached:    []Key{NewKey(1, 23)},
+			notCached: []Key{NewKey(12, 3)},
+			want:      Stats{Hits: 1, Misses: 1, Entries: 1, Bytes: entryOverhead + int64(len(`{"title":"1_23"}`))},
+		},
+		{
+			name: "least_recently_used_is_evicted",
+			conf: func(conf *config.Config) {
+				conf.Cache.MaxEntries = 2
+			},
+			set: func(bc *BannerCache) {
+				bc.Set(NewKey(1, 1), models.Content{})
+				bc.Set(NewKey(1, 2), models.Content{})
+				bc.Get(NewKey(1, 1))
+				bc.Set(NewKey(1, 3), models.Content{})
+			},
+			cached:    []Key{NewKey(1, 1), NewKey(1, 3)},
+			notCached: []Key{NewKey(1, 2)},
+			want:      Stats{Hits: 3, Misses: 1, Evictions: 1, Entries: 2, Bytes: 2 * (entryOverhead + int64(len(`{}`)))},
+		},
+		{
+			name: "max_bytes_is_respected",
+			conf: func(conf *config.Config) {
+				conf.Cache.MaxBytes = 2*entryOverhead + int64(len(`{"text":"aaaaaaaaaa"}`))
+			},
+			set: func(bc *BannerCache) {
+				bc.Set(NewKey(2, 1), models.Content{"text": strings.Repeat("a", 10)})
+				bc.Set(NewKey(2, 2), models.Content{"text": strings.Repeat("b", 10)})
+			},
+			cached:    []Key{NewKey(2, 2)},
+			notCached: []Key{NewKey(2, 1)},
+			want:      Stats{Hits: 1, Misses: 1, Evictions: 1, Entries: 1, Bytes: entryOverhead + int64(len(`{"text":"bbbbbbbbbb"}`))},
+		},
+		{
+			name: "expired_entry_is_dropped_on_get",
+			set: func(bc *BannerCache) {
+				bc.SetUntil(NewKey(3, 1), models.Content{}, time.Now().Add(-time.Second))
+				bc.Set(NewKey(3, 2), models.Content{})
+			},
+			cached:    []Key{NewKey(3, 2)},
+			notCached: []Key{NewKey(3, 1)},
+			want:      Stats{Hits: 1, Misses: 1, Expirations: 1, Entries: 1, Bytes: entryOverhead + int64(len(`{}`))},
+		},
+		{
+			name: "zero_ttls_fall_back_to_defaults",
+			conf: func(conf *config.Config) {
+				conf.Cache.HardTTL = 0
+				conf.Cache.SoftTTL = 0
+			},
+			set: func(bc *BannerCache) {
+				bc.Set(NewKey(7, 1), models.Content{})
+				if _, freshness, ok := bc.Lookup(NewKey(7, 1)); !ok || freshness != Fresh {
+					t.Errorf("Lookup() with default TTLs = %v, %v", freshness, ok)
+				}
+			},
+			cached: []Key{NewKey(7, 1)},
+			want:   Stats{Hits: 2, Entries: 1, Bytes: entryOverhead + int64(len(`{}`))},
+		},
+		{
+			name: "hard_expired_entry_is_kept_up_to_max_staleness",
+			conf: func(conf *config.Config) {
+				conf.Cache.HardTTL = time.Nanosecond
+				conf.Cache.MaxStaleness = time.Hour
+			},
+			set: func(bc *BannerCache) {
+				bc.Set(NewKey(5, 1), models.Content{})
+			},
+			notCached: []Key{NewKey(5, 1)},
+			want:      Stats{Misses: 1, Entries: 1, Bytes: entryOverhead + int64(len(`{}`))},
+		},
+		{
+			name: "not_found_is_cached_separately",
+			conf: func(conf *config.Config) {
+				conf.Cache.NegativeTTL = time.Minute
+			},
+			set: func(bc *BannerCache) {
+				bc.SetNotFound(NewKey(6, 1))
+				if content, freshness, ok := bc.Lookup(NewKey(6, 1)); !ok || content != nil || freshness != Fresh {
+					t.Errorf("Lookup() of not found pair = %v, %v, %v", content, freshness, ok)
+				}
+			},
+			notCached: []Key{NewKey(6, 1)},
+			want:      Stats{Hits: 2, Entries: 1, Bytes: entryOverhead},
+		},
+		{
+			name: "locales_are_cached_with_the_pair",
+			set: func(bc *BannerCache) {
+				bc.SetEntry(NewKey(6, 1), Entry{
+					Content: models.Content{},
+					Locales: map[string]models.Content{"kk": {"title": "kk"}},
+				}, time.Time{})
+				entry, _, _ := bc.Lookup(NewKey(6, 1))
+				if got := entry.Localized([]string{"ru", "kk"}); got.Content["title"] != "kk" || got.Locales != nil {
+					t.Errorf("Localized() = %v", got)
+				}
+			},
+			cached: []Key{NewKey(6, 1)},
+			want: Stats{Hits: 2, Entries: 1,
+				Bytes: entryOverhead + int64(len(`{}`)) + int64(len(`{"kk":{"title":"kk"}}`))},
+		},
+		{
+			name: "delete_and_flush",
+			set: func(bc *BannerCache) {
+				bc.Set(NewKey(4, 1), models.Content{})
+				bc.Set(NewKey(4, 2), models.Content{})
+				bc.Delete(NewKey(4, 1))
+				bc.Flush()
+			},
+			notCached: []Key{NewKey(4, 1), NewKey(4, 2)},
+			want:      Stats{Misses: 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := config.Config{}
+			conf.Cache.HardTTL = time.Hour
+			conf.Cache.Shards = 1
+			if tt.conf != nil {
+				tt.conf(&conf)
+			}
+
+			bc := NewBannerCache(context.Background(), 0, &conf)
+			tt.set(bc)
+
+			for _, key := range tt.cached {
+				if _, ok := bc.Get(key); !ok {
+					t.Errorf("key %v is not cached", key)
+				}
+			}
+			for _, key := range tt.notCached {
+				if _, ok := bc.Get(key); ok {
+					t.Errorf("key %v is cached", key)
+				}
+			}
+
+			if got := bc.Stats(); got != tt.want {
+				t.Errorf("Stats() got = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/mashmorsik/banners-service/config"
+	"github.com/mashmorsik/banners-service/infrastructure/data"
+	"github.com/mashmorsik/banners-service/pkg/models"
+	"github.com/mashmorsik/logger"
+)
+
+// InvalidationListener evicts banners changed by any instance. It listens on
+// data.BannerChangedChannel and flushes the whole cache after a reconnect, because
+// notifications sent while the connection was down are lost.
+type InvalidationListener struct {
+	Ctx      context.Context
+	Config   *config.Config
+	cache    *BannerCache
+	listener *pq.Listener
+}
+
+func NewInvalidationListener(ctx context.Context, conf *config.Config, cache *BannerCache) *InvalidationListener {
+	l := &InvalidationListener{Ctx: ctx, Config: conf, cache: cache}
+	l.listener = pq.NewListener(data.ConnectionString(conf),
+		conf.Cache.Invalidation.MinReconnectInterval, conf.Cache.Invalidation.MaxReconnectInterval, l.onEvent)
+
+	return l
+}
+
+// Listen handles notifications until the context is done.
+func (l *InvalidationListener) Listen() {
+	defer func() {
+		if err := l.listener.Close(); err != nil {
+			logger.Errf("can't close invalidation listener, err: %s", err)
+		}
+	}()
+
+	go func() {
+		if err := l.listener.Listen(data.BannerChangedChannel); err != nil {
+			logger.Errf("can't listen on %s, err: %s", data.BannerChangedChannel, err)
+		}
+	}()
+
+	pingInterval := l.Config.Cache.Invalidation.PingInterval
+	if pingInterval <= 0 {
+		pingInterval = 90 * time.Second
+	}
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.Ctx.Done():
+			return
+		case n := <-l.listener.Notify:
+			l.handle(n)
+		case <-ticker.C:
+			go func() {
+				if err := l.listener.Ping(); err != nil {
+					logger.Warn(fmt.Sprintf("invalidation listener ping failed: %s", err))
+				}
+			}()
+		}
+	}
+}
+
+func (l *InvalidationListener) handle(n *pq.Notification) {
+	// a nil notification is sent after the connection was re-established
+	if n == nil {
+		l.cache.Flush()
+		return
+	}
+
+	var change models.BannerChange
+	if err := json.Unmarshal([]byte(n.Extra), &change); err != nil {
+		logger.Errf("invalid banner change notification %q, flushing cache: %s", n.Extra, err)
+		l.cache.Flush()
+		return
+	}
+
+	if change.Flush {
+		l.cache.Flush()
+		return
+	}
+
+	for _, pair := range change.Pairs {
+		l.cache.Delete(NewKey(pair.FeatureID, pair.TagID))
+	}
+}
+
+func (l *InvalidationListener) onEvent(event pq.ListenerEventType, err error) {
+	switch event {
+	case pq.ListenerEventConnected:
+		logger.Infof("invalidation listener connected")
+	case pq.ListenerEventDisconnected:
+		logger.Warn(fmt.Sprintf("invalidation listener disconnected: %v", err))
+	case pq.ListenerEventReconnected:
+		logger.Infof("invalidation listener reconnected, flushing cache")
+		l.cache.Flush()
+	case pq.ListenerEventConnectionAttemptFailed:
+		logger.Warn(fmt.Sprintf("invalidation listener connection attempt failed: %v", err))
+	}
+}
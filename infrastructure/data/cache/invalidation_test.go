@@ -0,0 +1,70 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/mashmorsik/banners-service/config"
+	"github.com/mashmorsik/banners-service/pkg/models"
+	"github.com/mashmorsik/logger"
+)
+
+func TestInvalidationListener_handle(t *testing.T) {
+	logger.BuildLogger(nil)
+
+	conf := config.Config{}
+	conf.Cache.HardTTL = time.Hour
+
+	bannerCache := NewBannerCache(context.Background(), time.Hour, &conf)
+	l := &InvalidationListener{Config: &conf, cache: bannerCache}
+
+	tests := []struct {
+		name         string
+		notification *pq.Notification
+		evicted      []Key
+		kept         []Key
+	}{
+		{
+			name:         "evict_changed_pairs",
+			notification: &pq.Notification{Extra: `{"banner_ids":[1],"pairs":[{"feature_id":1,"tag_id":2}],"flush":false}`},
+			evicted:      []Key{NewKey(1, 2)},
+			kept:         []Key{NewKey(3, 4)},
+		},
+		{
+			name:         "flush_on_oversized_change",
+			notification: &pq.Notification{Extra: `{"banner_ids":[],"pairs":[],"flush":true}`},
+			evicted:      []Key{NewKey(1, 2), NewKey(3, 4)},
+		},
+		{
+			name:         "flush_on_reconnect",
+			notification: nil,
+			evicted:      []Key{NewKey(1, 2), NewKey(3, 4)},
+		},
+		{
+			name:         "flush_on_invalid_payload",
+			notification: &pq.Notification{Extra: `not json`},
+			evicted:      []Key{NewKey(1, 2), NewKey(3, 4)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bannerCache.Set(NewKey(1, 2), models.Content{"title": "changed"})
+			bannerCache.Set(NewKey(3, 4), models.Content{"title": "unrelated"})
+
+			l.handle(tt.notification)
+
+			for _, key := range tt.evicted {
+				if _, ok := bannerCache.Get(key); ok {
+					t.Errorf("key %v is still cached", key)
+				}
+			}
+			for _, key := range tt.kept {
+				if _, ok := bannerCache.Get(key); !ok {
+					t.Errorf("key %v was evicted", key)
+				}
+			}
+		})
+	}
+}
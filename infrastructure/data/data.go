@@ -14,6 +14,9 @@ import (
 	"os"
 )
 
+// BannerChangedChannel is the notification channel banner writes are announced on.
+const BannerChangedChannel = "banner_changed"
+
 type Data struct {
 	Ctx context.Context
 	db  *sql.DB
@@ -30,11 +33,13 @@ func (r *Data) Master() *sql.DB {
 	return r.db
 }
 
-func MustConnectPostgres(ctx context.Context, conf *config.Config) *sql.DB {
-	connectionStr := fmt.Sprintf("postgres://postgres:mysecretpassword@%s:%s/postgres?sslmode=disable&application_name=quotation&connect_timeout=5",
+func ConnectionString(conf *config.Config) string {
+	return fmt.Sprintf("postgres://postgres:mysecretpassword@%s:%s/postgres?sslmode=disable&application_name=quotation&connect_timeout=5",
 		conf.Postgres.Host, conf.Postgres.Port)
+}
 
-	connection, err := sql.Open("postgres", connectionStr)
+func MustConnectPostgres(ctx context.Context, conf *config.Config) *sql.DB {
+	connection, err := sql.Open("postgres", ConnectionString(conf))
 	if err != nil {
 		panic(err)
 	}
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	mw "github.com/mashmorsik/banners-service/pkg/middleware"
+	"github.com/mashmorsik/banners-service/pkg/models"
+	"github.com/mashmorsik/logger"
+)
+
+const (
+	maxNameLength        = 128
+	maxDescriptionLength = 1024
+)
+
+// catalogRouter separate router for the tag or feature catalog managed by administrators
+func (s *HTTPServer) catalogRouter(kind models.CatalogKind) chi.Router {
+	r := chi.NewRouter()
+	r.Use(mw.AdminAuthMiddleware)
+	r.Get("/", s.ListCatalog(kind))
+	r.Post("/", s.CreateCatalogEntry(kind))
+	r.Get("/{id}", s.GetCatalogEntry(kind))
+	r.Patch("/{id}", s.UpdateCatalogEntry(kind))
+	r.Delete("/{id}", s.DeleteCatalogEntry(kind))
+
+	return r
+}
+
+// ListCatalog returns the entries ordered by ID, archived ones only with include_archived.
+func (s *HTTPServer) ListCatalog(kind models.CatalogKind) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		v := &validator{}
+		includeArchived := v.optionalBool("include_archived", r.URL.Query().Get("include_archived"))
+		if !v.valid() {
+			s.writeValidationError(w, v)
+			return
+		}
+
+		entries, err := s.Banners.ListCatalog(kind, includeArchived)
+		if err != nil {
+			s.writeBannerError(w, err)
+			return
+		}
+
+		s.writeCatalogResponse(w, http.StatusOK, entries)
+	}
+}
+
+func (s *HTTPServer) GetCatalogEntry(kind models.CatalogKind) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		v := &validator{}
+		id := v.requiredInt("id", chi.URLParam(r, "id"), positive)
+		if !v.valid() {
+			s.writeValidationError(w, v)
+			return
+		}
+
+		entry, err := s.Banners.GetCatalogEntry(kind, id)
+		if err != nil {
+			s.writeBannerError(w, err)
+			return
+		}
+
+		s.writeCatalogResponse(w, http.StatusOK, entry)
+	}
+}
+
+func (s *HTTPServer) CreateCatalogEntry(kind models.CatalogKind) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		entry := &models.CatalogEntry{}
+		v := &validator{}
+		if v.decodeBody(r, entry) {
+			v.checkInt("id", entry.ID, positive)
+			if entry.Name == "" {
+				v.fail("name", "is required")
+			}
+			v.maxLength("name", entry.Name, maxNameLength)
+			v.maxLength("description", entry.Description, maxDescriptionLength)
+		}
+		if !v.valid() {
+			s.writeValidationError(w, v)
+			return
+		}
+
+		if err := s.Banners.CreateCatalogEntry(kind, entry); err != nil {
+			s.writeBannerError(w, err)
+			return
+		}
+
+		s.writeCatalogResponse(w, http.StatusCreated, entry)
+	}
+}
+
+// UpdateCatalogEntry renames, describes or archives an entry, fields missing in the body keep their values.
+func (s *HTTPServer) UpdateCatalogEntry(kind models.CatalogKind) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		patch := models.CatalogPatch{}
+		v := &validator{}
+		id := v.requiredInt("id", chi.URLParam(r, "id"), positive)
+		if v.decodeBody(r, &patch) {
+			if patch.Name != nil && *patch.Name == "" {
+				v.fail("name", "must not be empty")
+			}
+			if patch.Name != nil {
+				v.maxLength("name", *patch.Name, maxNameLength)
+			}
+			if patch.Description != nil {
+				v.maxLength("description", *patch.Description, maxDescriptionLength)
+			}
+		}
+		if !v.valid() {
+			s.writeValidationError(w, v)
+			return
+		}
+
+		entry, err := s.Banners.UpdateCatalogEntry(kind, id, patch)
+		if err != nil {
+			s.writeBannerError(w, err)
+			return
+		}
+
+		s.writeCatalogResponse(w, http.StatusOK, entry)
+	}
+}
+
+func (s *HTTPServer) DeleteCatalogEntry(kind models.CatalogKind) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		v := &validator{}
+		id := v.requiredInt("id", chi.URLParam(r, "id"), positive)
+		if !v.valid() {
+			s.writeValidationError(w, v)
+			return
+		}
+
+		if err := s.Banners.DeleteCatalogEntry(kind, id); err != nil {
+			s.writeBannerError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (s *HTTPServer) writeCatalogResponse(w http.ResponseWriter, status int, value any) {
+	jsonData, err := json.Marshal(value)
+	if err != nil {
+		logger.Errf("failed to marshal JSON: %v", err)
+		s.writeError(w, http.StatusInternalServerError, "Failed to marshal JSON")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	s.writeResponse(w, jsonData)
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/mashmorsik/banners-service/internal/banner"
+	"github.com/mashmorsik/banners-service/repository"
+	"github.com/mashmorsik/logger"
+	"github.com/pkg/errors"
+)
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// writeError writes the error body documented in swagger.yaml.
+func (s *HTTPServer) writeError(w http.ResponseWriter, status int, message string) {
+	jsonData, err := json.Marshal(errorResponse{Error: message})
+	if err != nil {
+		logger.Errf("failed to marshal JSON: %v", err)
+		http.Error(w, message, status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	s.writeResponse(w, jsonData)
+}
+
+// writeBannerError maps an error returned by banner.Banner to its status code. Details of
+// internal errors are logged instead of being sent to the client, validation errors with
+// known fields are written like request validation errors.
+func (s *HTTPServer) writeBannerError(w http.ResponseWriter, err error) {
+	var bannerErr *banner.Error
+	if errors.As(err, &bannerErr) && len(bannerErr.Fields) > 0 {
+		s.writeValidationError(w, &validator{errors: bannerErr.Fields})
+		return
+	}
+
+	status := statusOf(err)
+	if status == http.StatusInternalServerError {
+		logger.Errf("internal error: %v", err)
+		s.writeError(w, status, "internal server error")
+		return
+	}
+
+	s.writeError(w, status, err.Error())
+}
+
+func statusOf(err error) int {
+	// a version mismatch is a conflict caused by a stale If-Match header
+	if errors.Is(err, repository.ErrVersionMismatch) {
+		return http.StatusPreconditionFailed
+	}
+
+	switch banner.KindOf(err) {
+	case banner.KindNotFound:
+		return http.StatusNotFound
+	case banner.KindConflict:
+		return http.StatusConflict
+	case banner.KindValidation:
+		return http.StatusBadRequest
+	case banner.KindForbidden:
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
+	}
+}
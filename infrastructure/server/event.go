@@ -0,0 +1,93 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mashmorsik/banners-service/pkg/models"
+	"github.com/mashmorsik/logger"
+)
+
+const (
+	// maxEventsBytes limits the size of an events batch.
+	maxEventsBytes = 1 << 20
+	// defaultStatsDays is the range of the stats when it is not given.
+	defaultStatsDays = 30
+)
+
+type eventsRequest struct {
+	Events []models.Event `json:"events"`
+}
+
+type eventsResponse struct {
+	Accepted int `json:"accepted"`
+	Dropped  int `json:"dropped"`
+}
+
+// PostUserEvents accepts a batch of impressions and clicks, they are counted in memory
+// and written to the database in the background.
+func (s *HTTPServer) PostUserEvents(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxEventsBytes)
+
+	v := &validator{}
+	var req eventsRequest
+	if v.decodeBody(r, &req) {
+		v.events(req.Events, time.Now())
+	}
+	if !v.valid() {
+		s.writeValidationError(w, v)
+		return
+	}
+
+	accepted := s.Banners.RecordEvents(req.Events)
+
+	jsonData, err := json.Marshal(eventsResponse{Accepted: accepted, Dropped: len(req.Events) - accepted})
+	if err != nil {
+		logger.Errf("failed to marshal JSON: %v", err)
+		s.writeError(w, http.StatusInternalServerError, "Failed to marshal JSON")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	s.writeResponse(w, jsonData)
+}
+
+// GetBannerStats returns the daily impressions, clicks and CTR of every banner version,
+// the last 30 days by default.
+func (s *HTTPServer) GetBannerStats(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	v := &validator{}
+	bannerID := v.requiredInt("id", chi.URLParam(r, "id"), positive)
+	to := v.optionalDate("to", query.Get("to"))
+	if to.IsZero() {
+		to = time.Now().UTC().Truncate(24 * time.Hour)
+	}
+	from := v.optionalDate("from", query.Get("from"))
+	if from.IsZero() {
+		from = to.AddDate(0, 0, 1-defaultStatsDays)
+	}
+	v.dateRange("to", from, to)
+	if !v.valid() {
+		s.writeValidationError(w, v)
+		return
+	}
+
+	stats, err := s.Banners.EventStats(bannerID, from, to)
+	if err != nil {
+		s.writeBannerError(w, err)
+		return
+	}
+
+	jsonData, err := json.Marshal(stats)
+	if err != nil {
+		logger.Errf("failed to marshal JSON: %v", err)
+		s.writeError(w, http.StatusInternalServerError, "Failed to marshal JSON")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	s.writeResponse(w, jsonData)
+}
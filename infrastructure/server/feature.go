@@ -0,0 +1,87 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mashmorsik/banners-service/pkg/models"
+	"github.com/mashmorsik/logger"
+)
+
+// maxSchemaBytes limits the size of a content schema.
+const maxSchemaBytes = 64 << 10
+
+// featureRouter separate router for the feature catalog and feature settings managed by administrators
+func (s *HTTPServer) featureRouter() http.Handler {
+	r := s.catalogRouter(models.CatalogFeatures)
+	r.Get("/{id}/schema", s.GetFeatureSchema)
+	r.Put("/{id}/schema", s.PutFeatureSchema)
+
+	return r
+}
+
+// PutFeatureSchema registers the body as the next version of the feature content schema.
+func (s *HTTPServer) PutFeatureSchema(w http.ResponseWriter, r *http.Request) {
+	v := &validator{}
+	featureID := v.requiredInt("id", chi.URLParam(r, "id"), positive)
+	schema, err := io.ReadAll(io.LimitReader(r.Body, maxSchemaBytes+1))
+	switch {
+	case err != nil:
+		v.fail("body", "can't be read")
+	case len(schema) > maxSchemaBytes:
+		v.fail("body", "must not be larger than 65536 bytes")
+	case !json.Valid(schema):
+		v.fail("body", "must be a valid JSON object")
+	}
+	if !v.valid() {
+		s.writeValidationError(w, v)
+		return
+	}
+
+	result, err := s.Banners.PutSchema(featureID, schema)
+	if err != nil {
+		s.writeBannerError(w, err)
+		return
+	}
+
+	jsonData, err := json.Marshal(result)
+	if err != nil {
+		logger.Errf("failed to marshal JSON: %v", err)
+		s.writeError(w, http.StatusInternalServerError, "Failed to marshal JSON")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	s.writeResponse(w, jsonData)
+}
+
+// GetFeatureSchema returns the last version of the feature content schema, or the one
+// given by the version param.
+func (s *HTTPServer) GetFeatureSchema(w http.ResponseWriter, r *http.Request) {
+	v := &validator{}
+	featureID := v.requiredInt("id", chi.URLParam(r, "id"), positive)
+	version := v.optionalInt("version", r.URL.Query().Get("version"), positive)
+	if !v.valid() {
+		s.writeValidationError(w, v)
+		return
+	}
+
+	result, err := s.Banners.GetSchema(featureID, version)
+	if err != nil {
+		s.writeBannerError(w, err)
+		return
+	}
+
+	jsonData, err := json.Marshal(result)
+	if err != nil {
+		logger.Errf("failed to marshal JSON: %v", err)
+		s.writeError(w, http.StatusInternalServerError, "Failed to marshal JSON")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	s.writeResponse(w, jsonData)
+}
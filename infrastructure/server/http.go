@@ -4,8 +4,8 @@ import (
 	"context"
 	"encoding/json"
 	"net/http"
-	"slices"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -24,10 +24,10 @@ import (
 
 type HTTPServer struct {
 	Config  *config.Config
-	Banners banner.Banner
+	Banners *banner.Banner
 }
 
-func NewServer(conf *config.Config, banners banner.Banner) *HTTPServer {
+func NewServer(conf *config.Config, banners *banner.Banner) *HTTPServer {
 	return &HTTPServer{Config: conf, Banners: banners}
 }
 
@@ -42,6 +42,10 @@ func (s *HTTPServer) StartServer(ctx context.Context) error {
 	r.Get("/token", s.MakeToken)
 	r.Mount("/banner", s.adminRouter())
 	r.Mount("/user_banner", s.userRouter())
+	r.Mount("/jobs", s.jobsRouter())
+	r.Mount("/feature", s.featureRouter())
+	r.Mount("/tag", s.catalogRouter(models.CatalogTags))
+	r.With(mw.AdminAuthMiddleware).Get("/stats", s.GetStats)
 
 	r.Handle("/swagger.yaml", http.FileServer(http.Dir("./")))
 	r.Handle("/swagger", middleware.SwaggerUI(middleware.SwaggerUIOpts{
@@ -51,7 +55,7 @@ func (s *HTTPServer) StartServer(ctx context.Context) error {
 
 	httpServer := &http.Server{
 		Addr:              s.Config.Server.Port,
-		Handler:           cors.AllowAll().Handler(r),
+		Handler:           corsHandler().Handler(r),
 		ReadHeaderTimeout: 30 * time.Second,
 	}
 
@@ -71,6 +75,20 @@ func (s *HTTPServer) StartServer(ctx context.Context) error {
 	return nil
 }
 
+// corsHandler allows every origin like cors.AllowAll and exposes the headers the admin UI reads.
+func corsHandler() *cors.Cors {
+	return cors.New(cors.Options{
+		AllowedOrigins: []string{"*"},
+		AllowedMethods: []string{
+			http.MethodHead, http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete,
+		},
+		AllowedHeaders: []string{"*"},
+		ExposedHeaders: []string{
+			"ETag", "Location", "X-Total-Count", "X-Next-Cursor", "X-Banner-Id", "X-Banner-Version", "X-Banner-Variant",
+		},
+	})
+}
+
 // adminRouter separate router for administrator routes
 func (s *HTTPServer) adminRouter() http.Handler {
 	r := chi.NewRouter()
@@ -79,37 +97,54 @@ func (s *HTTPServer) adminRouter() http.Handler {
 	// GetAdminBanner returns all versions of banners with set params, it also shows which version is active now
 	r.Get("/", s.GetAdminBanner)
 	r.Post("/", s.CreateBanner)
+	r.Delete("/", s.BulkDeleteBanners)
+	r.Get("/trash", s.GetTrash)
+	r.Get("/search", s.SearchBanners)
+	r.Get("/{id}", s.GetBanner)
+	r.Get("/{id}/versions", s.GetBannerVersions)
+	r.Get("/{id}/diff", s.GetBannerDiff)
+	r.Get("/{id}/stats", s.GetBannerStats)
+	r.Post("/{id}/prune", s.PruneBanner)
+	r.Post("/{id}/restore", s.RestoreBanner)
 	r.Patch("/{id}", s.UpdateBanner)
 	r.Patch("/{id}/{v}", s.UpdateActiveVersion)
+	r.Put("/{id}/locales/{locale}", s.PutBannerLocale)
+	r.Delete("/{id}/locales/{locale}", s.DeleteBannerLocale)
 	r.Delete("/{id}", s.DeleteBanner)
 
 	return r
 }
 
+// jobsRouter separate router for background jobs started by administrators
+func (s *HTTPServer) jobsRouter() http.Handler {
+	r := chi.NewRouter()
+	r.Use(mw.AdminAuthMiddleware)
+	r.Get("/{id}", s.GetJob)
+
+	return r
+}
+
 // userRouter separate router for user routes
 func (s *HTTPServer) userRouter() http.Handler {
 	r := chi.NewRouter()
 	r.Use(mw.UserAuthMiddleware)
 	r.Get("/", s.GetUserBanner)
+	r.Post("/events", s.PostUserEvents)
 
 	return r
 }
 
 func (s *HTTPServer) GetUserBanner(w http.ResponseWriter, r *http.Request) {
-	tagID, err := strconv.Atoi(r.URL.Query().Get("tag_id"))
-	if err != nil {
-		http.Error(w, "invalid tagID", http.StatusBadRequest)
-	}
-
-	featureID, err := strconv.Atoi(r.URL.Query().Get("feature_id"))
-	if err != nil {
-		http.Error(w, "invalid featureID", http.StatusBadRequest)
-	}
-
-	useLatest := false
-	lastRevision := r.URL.Query().Get("use_last_revision")
-	if lastRevision != "" {
-		useLatest = true
+	query := r.URL.Query()
+	v := &validator{}
+	tagID := v.requiredInt("tag_id", query.Get("tag_id"), positive)
+	featureID := v.requiredInt("feature_id", query.Get("feature_id"), positive)
+	useLatest := v.flag(query.Get("use_last_revision"))
+	locales := v.userLocales(r)
+	userID := v.userID(r)
+	if !v.valid() {
+		s.writeValidationError(w, v)
+		return
 	}
 
 	reqBanner := &models.Banner{
@@ -118,208 +153,510 @@ func (s *HTTPServer) GetUserBanner(w http.ResponseWriter, r *http.Request) {
 		IsActive:  true,
 	}
 
-	var respBanner *models.Content
+	var respBanner *models.UserBanner
+	var err error
 	if useLatest {
-		respBanner, err = s.Banners.GetForUserLatest(reqBanner)
+		respBanner, err = s.Banners.GetForUserLatest(reqBanner, locales, userID)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			s.writeBannerError(w, err)
+			return
 		}
 	} else {
-		respBanner, err = s.Banners.GetForUser(reqBanner)
+		respBanner, err = s.Banners.GetForUser(reqBanner, locales, userID)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			s.writeBannerError(w, err)
+			return
 		}
 	}
 
-	jsonData, err := json.Marshal(respBanner)
+	jsonData, err := json.Marshal(respBanner.Content)
 	if err != nil {
 		logger.Errf("failed to marshal JSON: %v", err)
-		http.Error(w, "Failed to marshal JSON", http.StatusInternalServerError)
+		s.writeError(w, http.StatusInternalServerError, "Failed to marshal JSON")
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Vary", "Accept-Language")
+	w.Header().Set("X-Banner-Id", strconv.Itoa(respBanner.BannerID))
+	w.Header().Set("X-Banner-Version", strconv.Itoa(respBanner.Version))
+	if respBanner.Variant != "" {
+		w.Header().Set("X-Banner-Variant", respBanner.Variant)
+	}
+	s.writeResponse(w, jsonData)
+}
 
-	_, err = w.Write(jsonData)
+func (s *HTTPServer) GetAdminBanner(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	v := &validator{}
+	filter := v.bannerFilter(query)
+	page := v.page(query)
+	if !v.valid() {
+		s.writeValidationError(w, v)
+		return
+	}
+
+	result, err := s.Banners.GetForAdmin(filter, page)
 	if err != nil {
-		logger.Errf("failed to write response: %v", err)
-		http.Error(w, "Failed to write response", http.StatusInternalServerError)
+		s.writeBannerError(w, err)
 		return
 	}
+
+	jsonData, err := json.Marshal(result.Banners)
+	if err != nil {
+		logger.Errf("failed to marshal JSON: %v", err)
+		s.writeError(w, http.StatusInternalServerError, "Failed to marshal JSON")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Total-Count", strconv.Itoa(result.Total))
+	if result.NextCursor != nil {
+		w.Header().Set("X-Next-Cursor", result.NextCursor.Encode())
+	}
+	s.writeResponse(w, jsonData)
 }
 
-func (s *HTTPServer) GetAdminBanner(w http.ResponseWriter, r *http.Request) {
-	var tagID int
-	tagIDStr := r.URL.Query().Get("tag_id")
-	if tagIDStr != "" {
-		var err error
-		tagID, err = strconv.Atoi(tagIDStr)
-		if err != nil {
-			http.Error(w, "invalid featureID", http.StatusBadRequest)
-			return
-		}
+// SearchBanners runs a full-text search over the active versions of banners, or over
+// every version when all_versions is set.
+func (s *HTTPServer) SearchBanners(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	v := &validator{}
+	text := strings.TrimSpace(query.Get("q"))
+	if text == "" {
+		v.fail("q", "is required")
+	}
+	v.maxLength("q", text, maxQueryLength)
+	allVersions := v.optionalBool("all_versions", query.Get("all_versions"))
+	page := v.searchPage(query)
+	if !v.valid() {
+		s.writeValidationError(w, v)
+		return
 	}
 
-	var featureID int
-	featureIDStr := r.URL.Query().Get("feature_id")
-	if featureIDStr != "" {
-		var err error
-		featureID, err = strconv.Atoi(featureIDStr)
-		if err != nil {
-			http.Error(w, "invalid featureID", http.StatusBadRequest)
-			return
-		}
+	result, err := s.Banners.Search(text, allVersions, page)
+	if err != nil {
+		s.writeBannerError(w, err)
+		return
 	}
 
-	var limit int
-	limitStr := r.URL.Query().Get("limit")
-	if limitStr != "" {
-		var err error
-		limit, err = strconv.Atoi(limitStr)
-		if err != nil {
-			http.Error(w, "invalid limit", http.StatusBadRequest)
-			return
-		}
-	} else {
-		limit = 0
+	jsonData, err := json.Marshal(result.Hits)
+	if err != nil {
+		logger.Errf("failed to marshal JSON: %v", err)
+		s.writeError(w, http.StatusInternalServerError, "Failed to marshal JSON")
+		return
 	}
 
-	var offset int
-	offsetStr := r.URL.Query().Get("offset")
-	if offsetStr != "" {
-		var err error
-		offset, err = strconv.Atoi(offsetStr)
-		if err != nil {
-			http.Error(w, "invalid offset", http.StatusBadRequest)
-			return
-		}
-	} else {
-		offset = 0
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Total-Count", strconv.Itoa(result.Total))
+	if result.NextCursor != nil {
+		w.Header().Set("X-Next-Cursor", result.NextCursor.Encode())
 	}
+	s.writeResponse(w, jsonData)
+}
 
-	reqBanner := &models.Banner{
-		TagIDs:    append([]int{}, tagID),
-		FeatureID: featureID,
-		IsActive:  true,
+func (s *HTTPServer) GetBanner(w http.ResponseWriter, r *http.Request) {
+	v := &validator{}
+	bannerID := v.requiredInt("id", chi.URLParam(r, "id"), positive)
+	if !v.valid() {
+		s.writeValidationError(w, v)
+		return
 	}
 
-	var respBanner []*models.Banner
-	respBanner, err := s.Banners.GetForAdmin(reqBanner, limit, offset)
+	b, err := s.Banners.Get(bannerID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		s.writeBannerError(w, err)
+		return
 	}
 
-	jsonData, err := json.Marshal(respBanner)
+	jsonData, err := json.Marshal(b)
 	if err != nil {
 		logger.Errf("failed to marshal JSON: %v", err)
-		http.Error(w, "Failed to marshal JSON", http.StatusInternalServerError)
+		s.writeError(w, http.StatusInternalServerError, "Failed to marshal JSON")
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag(b.Revision))
+	s.writeResponse(w, jsonData)
+}
+
+func (s *HTTPServer) GetBannerVersions(w http.ResponseWriter, r *http.Request) {
+	v := &validator{}
+	bannerID := v.requiredInt("id", chi.URLParam(r, "id"), positive)
+	if !v.valid() {
+		s.writeValidationError(w, v)
+		return
+	}
 
-	_, err = w.Write(jsonData)
+	versions, err := s.Banners.GetVersions(bannerID)
 	if err != nil {
-		logger.Errf("failed to write response: %v", err)
-		http.Error(w, "Failed to write response", http.StatusInternalServerError)
+		s.writeBannerError(w, err)
+		return
+	}
+
+	jsonData, err := json.Marshal(versions)
+	if err != nil {
+		logger.Errf("failed to marshal JSON: %v", err)
+		s.writeError(w, http.StatusInternalServerError, "Failed to marshal JSON")
 		return
 	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag(versions[len(versions)-1].Revision))
+	s.writeResponse(w, jsonData)
 }
 
-func (s *HTTPServer) CreateBanner(w http.ResponseWriter, r *http.Request) {
-	var b *models.Banner
-	err := json.NewDecoder(r.Body).Decode(&b)
+func (s *HTTPServer) GetBannerDiff(w http.ResponseWriter, r *http.Request) {
+	v := &validator{}
+	bannerID := v.requiredInt("id", chi.URLParam(r, "id"), positive)
+	from := v.requiredInt("from", r.URL.Query().Get("from"), positive)
+	to := v.requiredInt("to", r.URL.Query().Get("to"), positive)
+	if !v.valid() {
+		s.writeValidationError(w, v)
+		return
+	}
+
+	diff, err := s.Banners.Diff(bannerID, from, to)
 	if err != nil {
-		http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+		s.writeBannerError(w, err)
 		return
 	}
 
-	err = s.Banners.Create(b)
+	jsonData, err := json.Marshal(diff)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		logger.Errf("failed to marshal JSON: %v", err)
+		s.writeError(w, http.StatusInternalServerError, "Failed to marshal JSON")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	s.writeResponse(w, jsonData)
+}
+
+func (s *HTTPServer) PruneBanner(w http.ResponseWriter, r *http.Request) {
+	v := &validator{}
+	bannerID := v.requiredInt("id", chi.URLParam(r, "id"), positive)
+	if !v.valid() {
+		s.writeValidationError(w, v)
+		return
+	}
+
+	pruned, err := s.Banners.Prune(bannerID)
+	if err != nil {
+		s.writeBannerError(w, err)
+		return
+	}
+
+	jsonData, err := json.Marshal(models.PruneResult{BannerID: bannerID, Pruned: pruned})
+	if err != nil {
+		logger.Errf("failed to marshal JSON: %v", err)
+		s.writeError(w, http.StatusInternalServerError, "Failed to marshal JSON")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	s.writeResponse(w, jsonData)
+}
+
+func (s *HTTPServer) CreateBanner(w http.ResponseWriter, r *http.Request) {
+	b := &models.Banner{}
+	v := &validator{}
+	if v.decodeBody(r, b) {
+		v.banner(b, false)
+	}
+	if !v.valid() {
+		s.writeValidationError(w, v)
+		return
+	}
+
+	if err := s.Banners.Create(b); err != nil {
+		s.writeBannerError(w, err)
+		return
 	}
 
 	w.WriteHeader(http.StatusCreated)
 }
 
 func (s *HTTPServer) UpdateBanner(w http.ResponseWriter, r *http.Request) {
-	var b *models.Banner
-	err := json.NewDecoder(r.Body).Decode(&b)
+	b := &models.Banner{}
+	v := &validator{}
+	bannerID := v.requiredInt("id", chi.URLParam(r, "id"), positive)
+	if v.decodeBody(r, b) {
+		v.banner(b, true)
+	}
+	if !v.valid() {
+		s.writeValidationError(w, v)
+		return
+	}
+	b.ID = bannerID
+
+	expectedRevision, ok := s.ifMatchRevision(w, r)
+	if !ok {
+		return
+	}
+
+	if err := s.Banners.Update(b, expectedRevision); err != nil {
+		s.writeBannerError(w, err)
+		return
+	}
+
+	w.Header().Set("ETag", etag(b.Revision))
+}
+
+func (s *HTTPServer) UpdateActiveVersion(w http.ResponseWriter, r *http.Request) {
+	v := &validator{}
+	bannerID := v.requiredInt("id", chi.URLParam(r, "id"), positive)
+	version := v.requiredInt("v", chi.URLParam(r, "v"), positive)
+	if !v.valid() {
+		s.writeValidationError(w, v)
+		return
+	}
+
+	expectedRevision, ok := s.ifMatchRevision(w, r)
+	if !ok {
+		return
+	}
+
+	revision, err := s.Banners.SetVersionActive(bannerID, version, expectedRevision)
 	if err != nil {
-		http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+		s.writeBannerError(w, err)
 		return
 	}
 
-	bannerIDStr := chi.URLParam(r, "id")
+	w.Header().Set("ETag", etag(revision))
+}
+
+// PutBannerLocale replaces the content of one locale of the banner version served to users,
+// the other locales and the version number are kept.
+func (s *HTTPServer) PutBannerLocale(w http.ResponseWriter, r *http.Request) {
+	v := &validator{}
+	bannerID := v.requiredInt("id", chi.URLParam(r, "id"), positive)
+	locale := v.locale("locale", chi.URLParam(r, "locale"))
+	var content models.Content
+	if v.decodeBody(r, &content) {
+		// a null body would remove the locale, DELETE does that
+		if content == nil {
+			v.fail("body", "must be a JSON object")
+		}
+		v.content("content", content)
+	}
+	if !v.valid() {
+		s.writeValidationError(w, v)
+		return
+	}
 
-	b.ID, err = strconv.Atoi(bannerIDStr)
+	expectedRevision, ok := s.ifMatchRevision(w, r)
+	if !ok {
+		return
+	}
+
+	revision, err := s.Banners.SetLocale(bannerID, locale, content, expectedRevision)
 	if err != nil {
-		http.Error(w, "invalid bannerID", http.StatusBadRequest)
+		s.writeBannerError(w, err)
+		return
+	}
+
+	w.Header().Set("ETag", etag(revision))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *HTTPServer) DeleteBannerLocale(w http.ResponseWriter, r *http.Request) {
+	v := &validator{}
+	bannerID := v.requiredInt("id", chi.URLParam(r, "id"), positive)
+	locale := v.locale("locale", chi.URLParam(r, "locale"))
+	if !v.valid() {
+		s.writeValidationError(w, v)
+		return
+	}
+
+	expectedRevision, ok := s.ifMatchRevision(w, r)
+	if !ok {
+		return
 	}
 
-	err = s.Banners.Update(b)
+	revision, err := s.Banners.SetLocale(bannerID, locale, nil, expectedRevision)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		s.writeBannerError(w, err)
+		return
 	}
+
+	w.Header().Set("ETag", etag(revision))
+	w.WriteHeader(http.StatusNoContent)
 }
 
-func (s *HTTPServer) UpdateActiveVersion(w http.ResponseWriter, r *http.Request) {
-	bannerIDStr := chi.URLParam(r, "id")
+func (s *HTTPServer) DeleteBanner(w http.ResponseWriter, r *http.Request) {
+	v := &validator{}
+	bannerID := v.requiredInt("id", chi.URLParam(r, "id"), positive)
+	if !v.valid() {
+		s.writeValidationError(w, v)
+		return
+	}
+
+	if err := s.Banners.Delete(bannerID); err != nil {
+		s.writeBannerError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
 
-	bannerID, err := strconv.Atoi(bannerIDStr)
+func (s *HTTPServer) BulkDeleteBanners(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	v := &validator{}
+	featureID := v.optionalInt("feature_id", query.Get("feature_id"), positive)
+	tagID := v.optionalInt("tag_id", query.Get("tag_id"), positive)
+	if query.Get("feature_id") == "" && query.Get("tag_id") == "" {
+		v.fail("feature_id", "feature_id or tag_id is required")
+	}
+	if !v.valid() {
+		s.writeValidationError(w, v)
+		return
+	}
+
+	j, err := s.Banners.BulkDelete(featureID, tagID)
 	if err != nil {
-		http.Error(w, "invalid bannerID", http.StatusBadRequest)
+		s.writeBannerError(w, err)
+		return
+	}
+
+	jsonData, err := json.Marshal(j)
+	if err != nil {
+		logger.Errf("failed to marshal JSON: %v", err)
+		s.writeError(w, http.StatusInternalServerError, "Failed to marshal JSON")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/jobs/"+j.ID)
+	w.WriteHeader(http.StatusAccepted)
+	s.writeResponse(w, jsonData)
+}
+
+func (s *HTTPServer) GetJob(w http.ResponseWriter, r *http.Request) {
+	v := &validator{}
+	jobID := v.requiredUUID("id", chi.URLParam(r, "id"))
+	if !v.valid() {
+		s.writeValidationError(w, v)
+		return
 	}
 
-	versionStr := chi.URLParam(r, "v")
-	version, err := strconv.Atoi(versionStr)
+	j, err := s.Banners.GetJob(jobID)
 	if err != nil {
-		http.Error(w, "invalid bannerID", http.StatusBadRequest)
+		s.writeBannerError(w, err)
+		return
 	}
 
-	err = s.Banners.SetVersionActive(bannerID, version)
+	jsonData, err := json.Marshal(j)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		logger.Errf("failed to marshal JSON: %v", err)
+		s.writeError(w, http.StatusInternalServerError, "Failed to marshal JSON")
+		return
 	}
+
+	w.Header().Set("Content-Type", "application/json")
+	s.writeResponse(w, jsonData)
 }
 
-func (s *HTTPServer) DeleteBanner(w http.ResponseWriter, r *http.Request) {
-	bannerIDStr := chi.URLParam(r, "id")
+func (s *HTTPServer) GetStats(w http.ResponseWriter, _ *http.Request) {
+	jsonData, err := json.Marshal(s.Banners.Stats())
+	if err != nil {
+		logger.Errf("failed to marshal JSON: %v", err)
+		s.writeError(w, http.StatusInternalServerError, "Failed to marshal JSON")
+		return
+	}
 
-	bannerID, err := strconv.Atoi(bannerIDStr)
+	w.Header().Set("Content-Type", "application/json")
+	s.writeResponse(w, jsonData)
+}
+
+func (s *HTTPServer) GetTrash(w http.ResponseWriter, _ *http.Request) {
+	banners, err := s.Banners.GetTrash()
 	if err != nil {
-		http.Error(w, "invalid bannerID", http.StatusBadRequest)
+		s.writeBannerError(w, err)
+		return
 	}
 
-	err = s.Banners.Delete(bannerID)
+	jsonData, err := json.Marshal(banners)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		logger.Errf("failed to marshal JSON: %v", err)
+		s.writeError(w, http.StatusInternalServerError, "Failed to marshal JSON")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	s.writeResponse(w, jsonData)
+}
+
+func (s *HTTPServer) RestoreBanner(w http.ResponseWriter, r *http.Request) {
+	v := &validator{}
+	bannerID := v.requiredInt("id", chi.URLParam(r, "id"), positive)
+	if !v.valid() {
+		s.writeValidationError(w, v)
+		return
+	}
+
+	if err := s.Banners.Restore(bannerID); err != nil {
+		s.writeBannerError(w, err)
+		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
 func (s *HTTPServer) MakeToken(w http.ResponseWriter, r *http.Request) {
-	role := r.URL.Query().Get("role")
-	if role == "" || !slices.Contains([]token.Role{token.RoleAdmin, token.RoleUser}, token.Role(role)) {
-		http.Error(w, "invalid role", http.StatusBadRequest)
+	v := &validator{}
+	role := v.oneOf("role", r.URL.Query().Get("role"), string(token.RoleAdmin), string(token.RoleUser))
+	if !v.valid() {
+		s.writeValidationError(w, v)
 		return
 	}
 
 	sign, err := token.Create(token.Role(role))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		logger.Errf("failed to create token: %v", err)
+		s.writeError(w, http.StatusInternalServerError, "failed to create token")
 		return
 	}
 
 	s.writeResponse(w, []byte(sign))
 }
 
+// writeResponse only logs a failed write, the status line is already sent by then.
 func (s *HTTPServer) writeResponse(w http.ResponseWriter, response []byte) {
 	_, err := w.Write(response)
 	if err != nil {
 		logger.Errf("failed to write response: %v", err)
-		http.Error(w, "failed to write response", http.StatusInternalServerError)
-		return
 	}
 }
+
+// etag exposes the revision of a banner as a strong entity tag.
+func etag(revision int) string {
+	return strconv.Quote(strconv.Itoa(revision))
+}
+
+// ifMatchRevision parses the If-Match header into the expected revision of the banner, zero
+// means any revision. It writes the error response itself and returns false on failure.
+func (s *HTTPServer) ifMatchRevision(w http.ResponseWriter, r *http.Request) (int, bool) {
+	ifMatch := strings.TrimSpace(r.Header.Get("If-Match"))
+	if ifMatch == "" {
+		if s.Config.Server.RequireIfMatch {
+			s.writeError(w, http.StatusPreconditionRequired, "If-Match header is required")
+			return 0, false
+		}
+		return 0, true
+	}
+	if ifMatch == "*" {
+		return 0, true
+	}
+
+	revision, err := strconv.Atoi(strings.Trim(strings.TrimPrefix(ifMatch, "W/"), `"`))
+	if err != nil || revision <= 0 {
+		v := &validator{}
+		v.fail("If-Match", "must be the ETag of the banner")
+		s.writeValidationError(w, v)
+		return 0, false
+	}
+
+	return revision, true
+}
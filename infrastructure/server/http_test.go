@@ -0,0 +1,58 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mashmorsik/banners-service/config"
+)
+
+func TestHTTPServer_PutBannerLocale(t *testing.T) {
+	s := NewServer(&config.Config{}, nil)
+
+	tests := []struct {
+		name       string
+		body       string
+		wantFields []FieldError
+	}{
+		{
+			name:       "null_body_does_not_remove_the_locale",
+			body:       `null`,
+			wantFields: []FieldError{{Field: "body", Message: "must be a JSON object"}},
+		},
+		{
+			name:       "empty_body",
+			body:       ``,
+			wantFields: []FieldError{{Field: "body", Message: "must be a valid JSON object"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPut, "/banner/1/locales/ru", strings.NewReader(tt.body))
+			routeCtx := chi.NewRouteContext()
+			routeCtx.URLParams.Add("id", "1")
+			routeCtx.URLParams.Add("locale", "ru")
+			r = r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, routeCtx))
+			w := httptest.NewRecorder()
+
+			s.PutBannerLocale(w, r)
+
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want %d", w.Code, http.StatusBadRequest)
+			}
+			var got validationErrorResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatalf("body = %s: %v", w.Body.String(), err)
+			}
+			if !reflect.DeepEqual(got.Fields, tt.wantFields) {
+				t.Errorf("fields = %v, want %v", got.Fields, tt.wantFields)
+			}
+		})
+	}
+}
@@ -1 +1,607 @@
 package server
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	mw "github.com/mashmorsik/banners-service/pkg/middleware"
+	"github.com/mashmorsik/banners-service/pkg/models"
+	"github.com/mashmorsik/logger"
+)
+
+const (
+	maxLimit       = 1000
+	maxQueryLength = 256
+	maxTitleLength = 256
+	maxTextLength  = 4096
+	maxURLLength   = 2048
+	// maxContentBytes limits the JSON size of a banner content.
+	maxContentBytes = 64 << 10
+	// maxLocales limits the fallback chain of a user request.
+	maxLocales       = 8
+	maxUserIDLength  = 256
+	maxVariants      = 16
+	maxVariantWeight = 1000000
+	maxEvents        = 500
+	// maxEventAge and maxEventSkew bound the timestamps of reported events, so late
+	// batches of offline clients are counted while wrong clocks are not.
+	maxEventAge  = 7 * 24 * time.Hour
+	maxEventSkew = 5 * time.Minute
+	maxStatsDays = 366
+)
+
+var (
+	// localePattern matches a lowercase language tag such as en, pt-br or zh-hant-tw.
+	localePattern     = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{1,8})*$`)
+	variantKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+)
+
+// FieldError tells which request field was rejected and why.
+type FieldError = models.FieldError
+
+type validationErrorResponse struct {
+	Error  string       `json:"error"`
+	Fields []FieldError `json:"fields"`
+}
+
+// intRule reports why a parsed integer is rejected, an empty message means it is valid.
+type intRule func(value int) string
+
+func positive(value int) string {
+	if value <= 0 {
+		return "must be a positive integer"
+	}
+	return ""
+}
+
+func nonNegative(value int) string {
+	if value < 0 {
+		return "must not be negative"
+	}
+	return ""
+}
+
+func atMost(limit int) intRule {
+	return func(value int) string {
+		if value > limit {
+			return fmt.Sprintf("must not be greater than %d", limit)
+		}
+		return ""
+	}
+}
+
+// validator collects every field error of a request, so the client can fix them at once.
+// Handlers declare their rules by reading params through it and check valid before use.
+type validator struct {
+	errors []FieldError
+}
+
+func (v *validator) fail(field, message string) {
+	v.errors = append(v.errors, FieldError{Field: field, Message: message})
+}
+
+func (v *validator) valid() bool {
+	return len(v.errors) == 0
+}
+
+func (v *validator) requiredInt(field, raw string, rules ...intRule) int {
+	if raw == "" {
+		v.fail(field, "is required")
+		return 0
+	}
+
+	return v.optionalInt(field, raw, rules...)
+}
+
+// optionalInt returns zero for a missing param, which means no filter for every endpoint.
+func (v *validator) optionalInt(field, raw string, rules ...intRule) int {
+	if raw == "" {
+		return 0
+	}
+
+	value, err := strconv.Atoi(raw)
+	if err != nil {
+		v.fail(field, "must be an integer")
+		return 0
+	}
+
+	v.checkInt(field, value, rules...)
+	return value
+}
+
+func (v *validator) checkInt(field string, value int, rules ...intRule) {
+	for _, rule := range rules {
+		if message := rule(value); message != "" {
+			v.fail(field, message)
+			return
+		}
+	}
+}
+
+// optionalIntList parses a comma separated list of integers.
+func (v *validator) optionalIntList(field, raw string, rules ...intRule) []int {
+	if raw == "" {
+		return nil
+	}
+
+	var values []int
+	for i, item := range strings.Split(raw, ",") {
+		itemField := fmt.Sprintf("%s[%d]", field, i)
+		value, err := strconv.Atoi(strings.TrimSpace(item))
+		if err != nil {
+			v.fail(itemField, "must be an integer")
+			continue
+		}
+		v.checkInt(itemField, value, rules...)
+		values = append(values, value)
+	}
+
+	return values
+}
+
+func (v *validator) optionalTime(field, raw string) *time.Time {
+	if raw == "" {
+		return nil
+	}
+
+	value, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		v.fail(field, "must be a RFC 3339 date-time")
+		return nil
+	}
+
+	return &value
+}
+
+// optionalDate parses a UTC day, a missing param is the zero time.
+func (v *validator) optionalDate(field, raw string) time.Time {
+	if raw == "" {
+		return time.Time{}
+	}
+
+	value, err := time.Parse(time.DateOnly, raw)
+	if err != nil {
+		v.fail(field, "must be a date in the YYYY-MM-DD format")
+		return time.Time{}
+	}
+
+	return value
+}
+
+// dateRange checks a range of days with both days included.
+func (v *validator) dateRange(field string, from, to time.Time) {
+	switch {
+	case to.Before(from):
+		v.fail(field, "must not be before from")
+	case to.Sub(from) >= maxStatsDays*24*time.Hour:
+		v.fail(field, fmt.Sprintf("must be less than %d days after from", maxStatsDays))
+	}
+}
+
+// timeRange checks that the range is not empty, both bounds are optional.
+func (v *validator) timeRange(field string, from, to *time.Time) {
+	if from != nil && to != nil && !to.After(*from) {
+		v.fail(field, "must end after it starts")
+	}
+}
+
+func (v *validator) optionalBool(field, raw string) bool {
+	if raw == "" {
+		return false
+	}
+
+	value, err := strconv.ParseBool(raw)
+	if err != nil {
+		v.fail(field, "must be a boolean")
+		return false
+	}
+
+	return value
+}
+
+// flag reads a parameter set by its presence, any non-empty value turns it on. Clients of
+// use_last_revision send values like "1" or "yes", so they are not parsed as booleans.
+func (v *validator) flag(raw string) bool {
+	return raw != ""
+}
+
+func (v *validator) optionalCursor(field, raw string) *models.Cursor {
+	if raw == "" {
+		return nil
+	}
+
+	cursor, err := models.DecodeCursor(raw)
+	if err != nil {
+		v.fail(field, "must be a cursor returned in X-Next-Cursor")
+		return nil
+	}
+
+	return cursor
+}
+
+func (v *validator) requiredUUID(field, raw string) string {
+	if _, err := uuid.Parse(raw); err != nil {
+		v.fail(field, "must be a UUID")
+	}
+
+	return raw
+}
+
+func (v *validator) oneOf(field, raw string, allowed ...string) string {
+	if !slices.Contains(allowed, raw) {
+		v.fail(field, fmt.Sprintf("must be one of: %s", strings.Join(allowed, ", ")))
+	}
+
+	return raw
+}
+
+// optionalOneOf returns the default value for a missing param.
+func (v *validator) optionalOneOf(field, raw, defaultValue string, allowed ...string) string {
+	if raw == "" {
+		return defaultValue
+	}
+
+	return v.oneOf(field, raw, allowed...)
+}
+
+// page reads the pagination and sort params shared by banner lists.
+func (v *validator) page(query url.Values) models.Page {
+	page := v.pageBounds(query)
+	if sort := query.Get("sort"); sort != "" || query.Get("order") != "" {
+		page.Sort = models.Sort{
+			Field: models.SortField(v.optionalOneOf("sort", sort, string(models.SortByUpdatedAt),
+				string(models.SortByID), string(models.SortByUpdatedAt), string(models.SortByFeatureID))),
+			Desc: v.optionalOneOf("order", query.Get("order"), "desc", "asc", "desc") == "desc",
+		}
+	}
+	if page.Cursor != nil && !page.Cursor.Matches(page.Sort.OrDefault()) {
+		v.fail("cursor", "was issued for another sort order")
+	}
+
+	return page
+}
+
+// searchPage reads the pagination params of search results, which are always ordered by rank.
+func (v *validator) searchPage(query url.Values) models.Page {
+	page := v.pageBounds(query)
+	page.Sort = models.Sort{Field: models.SortByRank, Desc: true}
+	if page.Cursor != nil && !page.Cursor.Matches(page.Sort) {
+		v.fail("cursor", "was issued for another sort order")
+	}
+
+	return page
+}
+
+func (v *validator) pageBounds(query url.Values) models.Page {
+	page := models.Page{
+		Limit:  v.optionalInt("limit", query.Get("limit"), nonNegative, atMost(maxLimit)),
+		Offset: v.optionalInt("offset", query.Get("offset"), nonNegative),
+		Cursor: v.optionalCursor("cursor", query.Get("cursor")),
+	}
+	if page.Cursor != nil && page.Offset != 0 {
+		v.fail("offset", "must not be combined with cursor")
+	}
+
+	return page
+}
+
+// bannerFilter reads the filters of the admin banner list, tag_id is kept for old clients
+// and joins the tag_ids list.
+func (v *validator) bannerFilter(query url.Values) models.BannerFilter {
+	filter := models.BannerFilter{
+		FeatureID: v.optionalInt("feature_id", query.Get("feature_id"), positive),
+		TagIDs:    v.optionalIntList("tag_ids", query.Get("tag_ids"), positive),
+		TagMatch: models.TagMatch(v.optionalOneOf("tag_match", query.Get("tag_match"), string(models.TagMatchAny),
+			string(models.TagMatchAny), string(models.TagMatchAll))),
+		CreatedFrom: v.optionalTime("created_from", query.Get("created_from")),
+		CreatedTo:   v.optionalTime("created_to", query.Get("created_to")),
+		UpdatedFrom: v.optionalTime("updated_from", query.Get("updated_from")),
+		UpdatedTo:   v.optionalTime("updated_to", query.Get("updated_to")),
+		Query:       strings.TrimSpace(query.Get("q")),
+	}
+	if tagID := v.optionalInt("tag_id", query.Get("tag_id"), positive); tagID != 0 {
+		filter.TagIDs = append(filter.TagIDs, tagID)
+	}
+	if raw := query.Get("is_active"); raw != "" {
+		isActive := v.optionalBool("is_active", raw)
+		filter.IsActive = &isActive
+	}
+	v.timeRange("created_to", filter.CreatedFrom, filter.CreatedTo)
+	v.timeRange("updated_to", filter.UpdatedFrom, filter.UpdatedTo)
+	v.maxLength("q", filter.Query, maxQueryLength)
+
+	return filter
+}
+
+// decodeBody reads the JSON body into dst, a malformed body is reported as a body field error.
+func (v *validator) decodeBody(r *http.Request, dst any) bool {
+	if err := json.NewDecoder(r.Body).Decode(dst); err != nil {
+		v.fail("body", "must be a valid JSON object")
+		return false
+	}
+
+	return true
+}
+
+// banner checks a banner to create, or to update when partial is set: zero fields of an
+// update keep their previous values, so only the fields that are set are checked.
+func (v *validator) banner(b *models.Banner, partial bool) {
+	if !partial || b.FeatureID != 0 {
+		v.checkInt("feature_id", b.FeatureID, positive)
+	}
+
+	if !partial && len(b.TagIDs) == 0 {
+		v.fail("tag_ids", "must not be empty")
+	}
+	for i, tagID := range b.TagIDs {
+		v.checkInt(fmt.Sprintf("tag_ids[%d]", i), tagID, positive)
+	}
+
+	v.content("content", b.Content)
+
+	// an update keeps the locales of the last version, they are edited one at a time
+	if partial && b.Locales != nil {
+		v.fail("locales", "must be set with PUT /banner/{id}/locales/{locale}")
+	}
+	v.localeContents("locales", b.Locales)
+	v.variants(b.Variants)
+}
+
+// localeContents checks the locales and their content in the order of the locales. The
+// locales are stored as they are, so they must be normalized already to be served.
+func (v *validator) localeContents(field string, contents map[string]models.Content) {
+	locales := make([]string, 0, len(contents))
+	for locale := range contents {
+		locales = append(locales, locale)
+	}
+	slices.Sort(locales)
+	for _, locale := range locales {
+		normalized := v.locale(field, locale)
+		if normalized == "" {
+			continue
+		}
+		if normalized != locale {
+			v.fail(field, fmt.Sprintf("must be written as %q, got %q", normalized, locale))
+			continue
+		}
+		v.content(field+"."+locale, contents[locale])
+	}
+}
+
+// variants checks the A/B test of a banner, the keys must be unique and at least one
+// variant must have a positive weight to get users.
+func (v *validator) variants(variants []models.Variant) {
+	if len(variants) > maxVariants {
+		v.fail("variants", fmt.Sprintf("must not have more than %d items", maxVariants))
+	}
+
+	keys := make(map[string]bool, len(variants))
+	var total int
+	for i, variant := range variants {
+		field := fmt.Sprintf("variants[%d]", i)
+		switch {
+		case !variantKeyPattern.MatchString(variant.Key):
+			v.fail(field+".key", "must be 1 to 64 letters, digits, hyphens or underscores")
+		case keys[variant.Key]:
+			v.fail(field+".key", fmt.Sprintf("must be unique, %q is repeated", variant.Key))
+		}
+		keys[variant.Key] = true
+
+		v.checkInt(field+".weight", variant.Weight, nonNegative, atMost(maxVariantWeight))
+		total += max(variant.Weight, 0)
+		v.content(field+".content", variant.Content)
+		v.localeContents(field+".locales", variant.Locales)
+	}
+	if len(variants) > 0 && total == 0 {
+		v.fail("variants", "must have a variant with a positive weight")
+	}
+}
+
+// content checks the size of the content and the known fields when they are set, any
+// other field is free-form.
+func (v *validator) content(field string, content models.Content) {
+	if data, err := json.Marshal(content); err == nil && len(data) > maxContentBytes {
+		v.fail(field, fmt.Sprintf("must not be larger than %d bytes", maxContentBytes))
+	}
+
+	if title, ok := v.contentString(field, content, "title"); ok {
+		v.maxLength(field+".title", title, maxTitleLength)
+	}
+	if text, ok := v.contentString(field, content, "text"); ok {
+		v.maxLength(field+".text", text, maxTextLength)
+	}
+	if link, ok := v.contentString(field, content, "url"); ok && link != "" && v.maxLength(field+".url", link, maxURLLength) {
+		if u, err := url.ParseRequestURI(link); err != nil || u.Host == "" ||
+			(u.Scheme != "http" && u.Scheme != "https") {
+			v.fail(field+".url", "must be an absolute http or https URL")
+		}
+	}
+}
+
+// contentString returns a known string field of the content, null removes a field on update
+// and is not checked.
+func (v *validator) contentString(field string, content models.Content, key string) (string, bool) {
+	value, ok := content[key]
+	if !ok || value == nil {
+		return "", false
+	}
+
+	str, ok := value.(string)
+	if !ok {
+		v.fail(field+"."+key, "must be a string")
+	}
+
+	return str, ok
+}
+
+// locale normalizes a language tag to lowercase with hyphens, so en_US and en-us are the
+// same locale.
+func (v *validator) locale(field, raw string) string {
+	locale := normalizeLocale(raw)
+	if !localePattern.MatchString(locale) {
+		v.fail(field, fmt.Sprintf("must be a language tag such as en or pt-br, got %q", raw))
+		return ""
+	}
+
+	return locale
+}
+
+// events checks a batch of reported events, a missing timestamp is set to now.
+func (v *validator) events(events []models.Event, now time.Time) {
+	switch {
+	case len(events) == 0:
+		v.fail("events", "must not be empty")
+	case len(events) > maxEvents:
+		v.fail("events", fmt.Sprintf("must not have more than %d items", maxEvents))
+		return
+	}
+
+	for i := range events {
+		event := &events[i]
+		field := fmt.Sprintf("events[%d]", i)
+		v.oneOf(field+".type", string(event.Type), string(models.EventImpression), string(models.EventClick))
+		v.checkInt(field+".banner_id", event.BannerID, positive)
+		v.checkInt(field+".version", event.Version, positive)
+		if event.Variant != "" && !variantKeyPattern.MatchString(event.Variant) {
+			v.fail(field+".variant", "must be a variant key returned in X-Banner-Variant")
+		}
+
+		switch {
+		case event.Timestamp.IsZero():
+			event.Timestamp = now
+		case event.Timestamp.After(now.Add(maxEventSkew)):
+			v.fail(field+".timestamp", "must not be in the future")
+		case event.Timestamp.Before(now.Add(-maxEventAge)):
+			v.fail(field+".timestamp", "must not be older than 7 days")
+		}
+	}
+}
+
+// userID returns the user of the token, the user_id param identifies the user when the
+// token has none. An empty id means the user is not assigned to banner variants.
+func (v *validator) userID(r *http.Request) string {
+	if userID := mw.UserID(r.Context()); userID != "" {
+		return userID
+	}
+
+	userID := strings.TrimSpace(r.URL.Query().Get("user_id"))
+	v.maxLength("user_id", userID, maxUserIDLength)
+
+	return userID
+}
+
+// userLocales reads the locale chain of a user request. The locale param takes precedence
+// over the Accept-Language header, no locale means the default content.
+func (v *validator) userLocales(r *http.Request) []string {
+	if raw := r.URL.Query().Get("locale"); raw != "" {
+		locale := v.locale("locale", raw)
+		if locale == "" {
+			return nil
+		}
+		return localeChain([]string{locale})
+	}
+
+	return localeChain(acceptLanguage(r.Header.Get("Accept-Language")))
+}
+
+// acceptLanguage returns the languages of the header by descending quality, the order of
+// equal ones is kept. The wildcard, malformed ranges and ranges with zero quality are skipped.
+func acceptLanguage(header string) []string {
+	type weighted struct {
+		locale  string
+		quality float64
+	}
+
+	var ranges []weighted
+	for _, item := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(item, ";")
+		locale := normalizeLocale(tag)
+		if !localePattern.MatchString(locale) {
+			continue
+		}
+
+		quality := 1.0
+		for _, param := range strings.Split(params, ";") {
+			name, value, ok := strings.Cut(param, "=")
+			if !ok || strings.TrimSpace(name) != "q" {
+				continue
+			}
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil {
+				parsed = 0
+			}
+			quality = parsed
+		}
+		if quality <= 0 {
+			continue
+		}
+
+		ranges = append(ranges, weighted{locale: locale, quality: quality})
+	}
+	slices.SortStableFunc(ranges, func(a, b weighted) int {
+		return cmp.Compare(b.quality, a.quality)
+	})
+
+	locales := make([]string, len(ranges))
+	for i, r := range ranges {
+		locales[i] = r.locale
+	}
+
+	return locales
+}
+
+// localeChain follows every locale by its shorter forms, so pt-br falls back to pt before
+// the next preferred language, and drops the repeated ones.
+func localeChain(locales []string) []string {
+	var chain []string
+	for _, locale := range locales {
+		for {
+			if !slices.Contains(chain, locale) && len(chain) < maxLocales {
+				chain = append(chain, locale)
+			}
+			cut := strings.LastIndexByte(locale, '-')
+			if cut < 0 {
+				break
+			}
+			locale = locale[:cut]
+		}
+	}
+
+	return chain
+}
+
+func normalizeLocale(raw string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(raw), "_", "-"))
+}
+
+func (v *validator) maxLength(field, value string, limit int) bool {
+	if len([]rune(value)) > limit {
+		v.fail(field, fmt.Sprintf("must not be longer than %d characters", limit))
+		return false
+	}
+
+	return true
+}
+
+func (s *HTTPServer) writeValidationError(w http.ResponseWriter, v *validator) {
+	jsonData, err := json.Marshal(validationErrorResponse{Error: "invalid request", Fields: v.errors})
+	if err != nil {
+		logger.Errf("failed to marshal JSON: %v", err)
+		s.writeError(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusBadRequest)
+	s.writeResponse(w, jsonData)
+}
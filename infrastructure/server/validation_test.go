@@ -0,0 +1,361 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mashmorsik/banners-service/pkg/models"
+)
+
+func TestValidator(t *testing.T) {
+	tests := []struct {
+		name     string
+		validate func(v *validator)
+		want     []FieldError
+	}{
+		{
+			name: "valid_params",
+			validate: func(v *validator) {
+				v.requiredInt("tag_id", "2", positive)
+				v.optionalInt("limit", "", nonNegative, atMost(maxLimit))
+				v.optionalBool("all_versions", "true")
+			},
+		},
+		{
+			name: "use_last_revision_is_set_by_any_value",
+			validate: func(v *validator) {
+				if !v.flag("yes") || !v.flag("1") || v.flag("") {
+					t.Error("flag() must be on for any non-empty value")
+				}
+			},
+		},
+		{
+			name: "errors_are_aggregated",
+			validate: func(v *validator) {
+				v.requiredInt("tag_id", "", positive)
+				v.requiredInt("feature_id", "abc", positive)
+				v.optionalInt("limit", "5000", nonNegative, atMost(maxLimit))
+				v.optionalInt("offset", "-1", nonNegative)
+				v.optionalBool("all_versions", "maybe")
+			},
+			want: []FieldError{
+				{Field: "tag_id", Message: "is required"},
+				{Field: "feature_id", Message: "must be an integer"},
+				{Field: "limit", Message: "must not be greater than 1000"},
+				{Field: "offset", Message: "must not be negative"},
+				{Field: "all_versions", Message: "must be a boolean"},
+			},
+		},
+		{
+			name: "cursor_round_trip",
+			validate: func(v *validator) {
+				cursor := &models.Cursor{UpdatedAt: time.Date(2024, 4, 1, 10, 0, 0, 123000, time.UTC), ID: 3}
+				if got := v.optionalCursor("cursor", cursor.Encode()); !reflect.DeepEqual(got, cursor) {
+					v.fail("cursor", "decoded cursor differs")
+				}
+				v.optionalCursor("cursor", "not_a_cursor")
+			},
+			want: []FieldError{
+				{Field: "cursor", Message: "must be a cursor returned in X-Next-Cursor"},
+			},
+		},
+		{
+			name: "admin_list_params",
+			validate: func(v *validator) {
+				query := url.Values{
+					"tag_id":       {"4"},
+					"tag_ids":      {"1, 2,x"},
+					"tag_match":    {"every"},
+					"is_active":    {"false"},
+					"created_from": {"2024-04-02T00:00:00Z"},
+					"created_to":   {"2024-04-01T00:00:00Z"},
+					"updated_from": {"yesterday"},
+					"sort":         {"feature_id"},
+					"order":        {"asc"},
+					"cursor":       {(&models.Cursor{Sort: models.SortByUpdatedAt, Desc: true, UpdatedAt: time.Now(), ID: 1}).Encode()},
+				}
+				filter := v.bannerFilter(query)
+				page := v.page(query)
+				if !reflect.DeepEqual(filter.TagIDs, []int{1, 2, 4}) || filter.IsActive == nil || *filter.IsActive {
+					v.fail("filter", "parsed wrong")
+				}
+				if page.Sort != (models.Sort{Field: models.SortByFeatureID}) {
+					v.fail("page", "parsed wrong")
+				}
+			},
+			want: []FieldError{
+				{Field: "tag_ids[2]", Message: "must be an integer"},
+				{Field: "tag_match", Message: "must be one of: any, all"},
+				{Field: "updated_from", Message: "must be a RFC 3339 date-time"},
+				{Field: "created_to", Message: "must end after it starts"},
+				{Field: "cursor", Message: "was issued for another sort order"},
+			},
+		},
+		{
+			name: "search_page",
+			validate: func(v *validator) {
+				query := url.Values{
+					"limit":  {"20"},
+					"sort":   {"id"},
+					"cursor": {(&models.Cursor{Sort: models.SortByID, ID: 1}).Encode()},
+				}
+				page := v.searchPage(query)
+				if page.Limit != 20 || page.Sort != (models.Sort{Field: models.SortByRank, Desc: true}) {
+					v.fail("page", "parsed wrong")
+				}
+
+				rankCursor := &models.Cursor{Sort: models.SortByRank, Desc: true, Rank: 0.0607927, ID: 2}
+				v.searchPage(url.Values{"cursor": {rankCursor.Encode()}})
+			},
+			want: []FieldError{
+				{Field: "cursor", Message: "was issued for another sort order"},
+			},
+		},
+		{
+			name: "valid_banner",
+			validate: func(v *validator) {
+				v.banner(&models.Banner{
+					FeatureID: 1,
+					TagIDs:    []int{1, 2},
+					Content:   models.Content{"title": "title", "url": "https://example.com/banner"},
+				}, false)
+			},
+		},
+		{
+			name: "invalid_banner",
+			validate: func(v *validator) {
+				v.banner(&models.Banner{
+					TagIDs: []int{0},
+					Content: models.Content{
+						"title": strings.Repeat("т", maxTitleLength+1),
+						"url":   "some_url",
+					},
+				}, false)
+			},
+			want: []FieldError{
+				{Field: "feature_id", Message: "must be a positive integer"},
+				{Field: "tag_ids[0]", Message: "must be a positive integer"},
+				{Field: "content.title", Message: "must not be longer than 256 characters"},
+				{Field: "content.url", Message: "must be an absolute http or https URL"},
+			},
+		},
+		{
+			name: "free_form_content",
+			validate: func(v *validator) {
+				v.banner(&models.Banner{
+					Content: models.Content{
+						"title":   json.Number("42"),
+						"text":    nil,
+						"buttons": []any{map[string]any{"label": "buy", "color": "#ff0000"}},
+						"images":  strings.Split(strings.Repeat("i", maxContentBytes), ""),
+					},
+				}, true)
+			},
+			want: []FieldError{
+				{Field: "content", Message: "must not be larger than 65536 bytes"},
+				{Field: "content.title", Message: "must be a string"},
+			},
+		},
+		{
+			name: "partial_update_checks_set_fields_only",
+			validate: func(v *validator) {
+				v.banner(&models.Banner{Content: models.Content{"text": "new text"}}, true)
+				v.banner(&models.Banner{FeatureID: -1}, true)
+			},
+			want: []FieldError{
+				{Field: "feature_id", Message: "must be a positive integer"},
+			},
+		},
+		{
+			name: "banner_locales",
+			validate: func(v *validator) {
+				v.banner(&models.Banner{
+					FeatureID: 1,
+					TagIDs:    []int{1},
+					Locales: map[string]models.Content{
+						"ru":      {"title": strings.Repeat("т", maxTitleLength+1)},
+						"en-US!":  {"title": "title"},
+						"pt-br":   {"url": "https://example.com/pt"},
+						"english": {},
+					},
+				}, false)
+				v.banner(&models.Banner{Locales: map[string]models.Content{"ru": {}}}, true)
+			},
+			want: []FieldError{
+				{Field: "locales", Message: `must be a language tag such as en or pt-br, got "en-US!"`},
+				{Field: "locales", Message: `must be a language tag such as en or pt-br, got "english"`},
+				{Field: "locales.ru.title", Message: "must not be longer than 256 characters"},
+				{Field: "locales", Message: "must be set with PUT /banner/{id}/locales/{locale}"},
+			},
+		},
+		{
+			name: "banner_variants",
+			validate: func(v *validator) {
+				v.banner(&models.Banner{
+					FeatureID: 1,
+					TagIDs:    []int{1},
+					Variants: []models.Variant{
+						{Key: "control", Weight: 0},
+						{Key: "control", Weight: -1},
+						{Key: "new title", Content: models.Content{"title": 1}},
+						{Key: "b", Locales: map[string]models.Content{"RU": {}, "ru": {"url": "ftp://example.com"}}},
+					},
+				}, true)
+				v.banner(&models.Banner{Variants: []models.Variant{{Key: "a", Weight: 1}}}, true)
+			},
+			want: []FieldError{
+				{Field: "variants[1].key", Message: `must be unique, "control" is repeated`},
+				{Field: "variants[1].weight", Message: "must not be negative"},
+				{Field: "variants[2].key", Message: "must be 1 to 64 letters, digits, hyphens or underscores"},
+				{Field: "variants[2].content.title", Message: "must be a string"},
+				{Field: "variants[3].locales", Message: `must be written as "ru", got "RU"`},
+				{Field: "variants[3].locales.ru.url", Message: "must be an absolute http or https URL"},
+				{Field: "variants", Message: "must have a variant with a positive weight"},
+			},
+		},
+		{
+			name: "user_id",
+			validate: func(v *validator) {
+				v.userID(httptest.NewRequest("GET", "/user_banner?user_id=42", nil))
+				v.userID(httptest.NewRequest("GET", "/user_banner?user_id="+strings.Repeat("1", maxUserIDLength+1), nil))
+			},
+			want: []FieldError{
+				{Field: "user_id", Message: "must not be longer than 256 characters"},
+			},
+		},
+		{
+			name: "events",
+			validate: func(v *validator) {
+				now := time.Date(2024, 4, 10, 12, 0, 0, 0, time.UTC)
+				events := []models.Event{
+					{Type: models.EventImpression, BannerID: 1, Version: 2, Variant: "a"},
+					{Type: models.EventClick, BannerID: 1, Version: 2, Timestamp: now.Add(-time.Hour)},
+					{Type: "view", BannerID: 0, Version: 1, Variant: "a b", Timestamp: now.Add(time.Hour)},
+					{Type: models.EventClick, BannerID: 1, Version: -1, Timestamp: now.AddDate(0, 0, -8)},
+				}
+				v.events(events, now)
+				if !events[0].Timestamp.Equal(now) {
+					v.fail("events[0].timestamp", "is not set to now")
+				}
+				v.events(nil, now)
+				v.events(make([]models.Event, maxEvents+1), now)
+			},
+			want: []FieldError{
+				{Field: "events[2].type", Message: "must be one of: impression, click"},
+				{Field: "events[2].banner_id", Message: "must be a positive integer"},
+				{Field: "events[2].variant", Message: "must be a variant key returned in X-Banner-Variant"},
+				{Field: "events[2].timestamp", Message: "must not be in the future"},
+				{Field: "events[3].version", Message: "must be a positive integer"},
+				{Field: "events[3].timestamp", Message: "must not be older than 7 days"},
+				{Field: "events", Message: "must not be empty"},
+				{Field: "events", Message: "must not have more than 500 items"},
+			},
+		},
+		{
+			name: "stats_dates",
+			validate: func(v *validator) {
+				from := v.optionalDate("from", "2024-04-01")
+				v.dateRange("to", from, v.optionalDate("to", "2024-04-01"))
+				v.dateRange("to", from, v.optionalDate("to", "2024-03-31"))
+				v.dateRange("to", from, v.optionalDate("to", "2025-04-02"))
+				if !v.optionalDate("from", "").IsZero() {
+					v.fail("from", "is not zero")
+				}
+				v.optionalDate("from", "2024-04-01T00:00:00Z")
+			},
+			want: []FieldError{
+				{Field: "to", Message: "must not be before from"},
+				{Field: "to", Message: "must be less than 366 days after from"},
+				{Field: "from", Message: "must be a date in the YYYY-MM-DD format"},
+			},
+		},
+		{
+			name: "malformed_body",
+			validate: func(v *validator) {
+				v.decodeBody(httptest.NewRequest("POST", "/banner", strings.NewReader("{")), &models.Banner{})
+			},
+			want: []FieldError{
+				{Field: "body", Message: "must be a valid JSON object"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := &validator{}
+			tt.validate(v)
+			if !reflect.DeepEqual(v.errors, tt.want) {
+				t.Errorf("validator errors got = %+v, want %+v", v.errors, tt.want)
+			}
+			if v.valid() != (len(tt.want) == 0) {
+				t.Errorf("valid() got = %v, want %v", v.valid(), len(tt.want) == 0)
+			}
+		})
+	}
+}
+
+func TestValidator_userLocales(t *testing.T) {
+	tests := []struct {
+		name           string
+		query          string
+		acceptLanguage string
+		want           []string
+		wantErrors     []FieldError
+	}{
+		{
+			name: "no_locale",
+		},
+		{
+			name:           "accept_language_by_quality",
+			acceptLanguage: "en;q=0.5, ru-RU, kk;q=0.8, *;q=0.1",
+			want:           []string{"ru-ru", "ru", "kk", "en"},
+		},
+		{
+			name:           "zero_quality_and_malformed_ranges_are_skipped",
+			acceptLanguage: "de;q=0, fr-CA;q=abc, en_GB, 12",
+			want:           []string{"en-gb", "en"},
+		},
+		{
+			name:           "repeated_locales_are_dropped",
+			acceptLanguage: "pt-BR, pt, pt-PT;q=0.9",
+			want:           []string{"pt-br", "pt", "pt-pt"},
+		},
+		{
+			name:           "param_overrides_header",
+			query:          "locale=zh_Hant_TW",
+			acceptLanguage: "en",
+			want:           []string{"zh-hant-tw", "zh-hant", "zh"},
+		},
+		{
+			name:       "invalid_param",
+			query:      "locale=russian!",
+			wantErrors: []FieldError{{Field: "locale", Message: `must be a language tag such as en or pt-br, got "russian!"`}},
+		},
+		{
+			name:           "chain_is_capped",
+			acceptLanguage: "a1, aa, ab, ac, ad, ae, af, ag, ah, ai, ak",
+			want:           []string{"aa", "ab", "ac", "ad", "ae", "af", "ag", "ah"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/user_banner?"+tt.query, nil)
+			if tt.acceptLanguage != "" {
+				r.Header.Set("Accept-Language", tt.acceptLanguage)
+			}
+
+			v := &validator{}
+			got := v.userLocales(r)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("userLocales() got = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(v.errors, tt.wantErrors) {
+				t.Errorf("validator errors got = %+v, want %+v", v.errors, tt.wantErrors)
+			}
+		})
+	}
+}
@@ -4,13 +4,18 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
-	"strconv"
+	"sync/atomic"
+	"time"
 
 	"github.com/mashmorsik/banners-service/config"
 	"github.com/mashmorsik/banners-service/infrastructure/data/cache"
+	"github.com/mashmorsik/banners-service/internal/event"
+	"github.com/mashmorsik/banners-service/internal/job"
 	"github.com/mashmorsik/banners-service/pkg/models"
 	"github.com/mashmorsik/banners-service/repository"
+	"github.com/mashmorsik/logger"
 	errs "github.com/pkg/errors"
+	"golang.org/x/sync/singleflight"
 )
 
 type Banner struct {
@@ -18,53 +23,190 @@ type Banner struct {
 	Repo   repository.Repository
 	Config *config.Config
 	Cache  *cache.BannerCache
+	Jobs   *job.Manager
+	Events *event.Buffer
+
+	// loads coalesces concurrent cache misses of the same feature/tag key into one query.
+	loads       singleflight.Group
+	coalesced   atomic.Uint64
+	refreshes   atomic.Uint64
+	servedStale atomic.Uint64
+}
+
+// Stats reports cache counters, the number of user requests that waited for a query
+// started by another request instead of running their own, background refreshes,
+// requests served past the hard TTL because the database was unavailable and the
+// counters of the event buffer.
+type Stats struct {
+	Cache       cache.Stats `json:"cache"`
+	Coalesced   uint64      `json:"coalesced"`
+	Refreshes   uint64      `json:"refreshes"`
+	ServedStale uint64      `json:"served_stale"`
+	Events      event.Stats `json:"events"`
 }
 
 func NewBanner(ctx context.Context, repo repository.Repository, conf *config.Config, cache *cache.BannerCache) *Banner {
-	return &Banner{Ctx: ctx, Repo: repo, Config: conf, Cache: cache}
+	return &Banner{
+		Ctx:    ctx,
+		Repo:   repo,
+		Config: conf,
+		Cache:  cache,
+		Jobs:   job.NewManager(ctx, repo, conf.Jobs.TTL),
+		Events: event.NewBuffer(ctx, repo.AddEventCounts, conf.Events.FlushInterval, conf.Events.FlushTimeout,
+			conf.Events.MaxPending),
+	}
 }
 
-func (b *Banner) GetForUser(req *models.Banner) (*models.Content, error) {
-	cacheKey := strconv.Itoa(req.FeatureID) + strconv.Itoa(req.TagIDs[0])
-	content, ok := b.Cache.Get(cacheKey)
-	if !ok {
-		banner, err := b.Repo.GetForUser(req)
+// GetForUser serves content from the cache. Content past the soft TTL is returned at once
+// and refreshed in the background, content past the hard TTL is reloaded and served only
+// when the database can't be reached, up to the configured max staleness. The content of
+// the first of the locales the banner has is served, the default content otherwise. The
+// banner is cached per pair with all of its locales and localized per request. Users of a
+// banner with variants get the content of the variant assigned to the userID.
+func (b *Banner) GetForUser(req *models.Banner, locales []string, userID string) (*models.UserBanner, error) {
+	cacheKey := cache.NewKey(req.FeatureID, req.TagIDs[0])
+	cached, freshness, ok := b.Cache.Lookup(cacheKey)
+	if ok && cached == nil && freshness != cache.Expired {
+		return nil, errs.WithMessage(sql.ErrNoRows, "banner not found")
+	}
+	if ok && freshness == cache.Fresh {
+		return userBanner(cached.Localized(locales), cacheKey, userID), nil
+	}
+	if ok && freshness == cache.Stale {
+		b.refresh(cacheKey, req)
+		return userBanner(cached.Localized(locales), cacheKey, userID), nil
+	}
+
+	var leader bool
+	v, err, shared := b.loads.Do(loadKey(cacheKey), func() (any, error) {
+		leader = true
+		return b.load(cacheKey, req)
+	})
+	if shared && !leader {
+		b.coalesced.Add(1)
+	}
+	if err != nil {
+		if cached != nil && !errs.Is(err, sql.ErrNoRows) {
+			logger.Errf("fail to reload banner for feature: %d and tag: %d, serving stale content: %s",
+				cacheKey.FeatureID, cacheKey.TagID, err)
+			b.servedStale.Add(1)
+			return userBanner(cached.Localized(locales), cacheKey, userID), nil
+		}
+		return nil, errs.WithMessage(err, "banner not found")
+	}
+
+	entry := v.(cache.Entry)
+	return userBanner(entry.Localized(locales), cacheKey, userID), nil
+}
+
+// refresh reloads the content in the background unless a load of the key is in flight.
+func (b *Banner) refresh(cacheKey cache.Key, req *models.Banner) {
+	b.loads.DoChan(loadKey(cacheKey), func() (any, error) {
+		b.refreshes.Add(1)
+		entry, err := b.load(cacheKey, req)
 		if err != nil {
-			return nil, errs.WithMessage(err, "banner not found")
+			logger.Errf("fail to refresh banner for feature: %d and tag: %d: %s",
+				cacheKey.FeatureID, cacheKey.TagID, err)
 		}
+		return entry, err
+	})
+}
 
-		b.Cache.Set(cacheKey, banner.Content)
+func (b *Banner) load(cacheKey cache.Key, req *models.Banner) (cache.Entry, error) {
+	banner, err := b.Repo.GetForUser(req)
+	if err != nil {
+		if errs.Is(err, sql.ErrNoRows) {
+			b.Cache.SetNotFound(cacheKey)
+		}
+		return cache.Entry{}, err
+	}
+	entry := cacheEntry(banner)
+	b.cacheContent(cacheKey, entry, banner.EndsAt)
+
+	return entry, nil
+}
 
-		return &banner.Content, nil
+// cacheContent keeps the content no longer than the banner is scheduled to be shown.
+func (b *Banner) cacheContent(cacheKey cache.Key, entry cache.Entry, endsAt *time.Time) {
+	var until time.Time
+	if endsAt != nil {
+		until = *endsAt
 	}
+	b.Cache.SetEntry(cacheKey, entry, until)
+}
+
+// cacheEntry keeps what users of any locale are served from the banner.
+func cacheEntry(banner *models.Banner) cache.Entry {
+	return cache.Entry{
+		BannerID: banner.ID,
+		Version:  banner.Version,
+		Content:  banner.Content,
+		Locales:  banner.Locales,
+		Variants: banner.Variants,
+	}
+}
 
-	return content, nil
+func loadKey(cacheKey cache.Key) string {
+	return fmt.Sprintf("%d_%d", cacheKey.FeatureID, cacheKey.TagID)
 }
 
-func (b *Banner) GetForUserLatest(req *models.Banner) (*models.Content, error) {
-	content, err := b.Repo.GetForUser(req)
+func (b *Banner) GetForUserLatest(req *models.Banner, locales []string, userID string) (*models.UserBanner, error) {
+	banner, err := b.Repo.GetForUser(req)
 	if err != nil {
 		return nil, errs.WithMessage(err, "banner not found")
 	}
 
-	return &content.Content, nil
+	entry := cacheEntry(banner)
+	return userBanner(entry.Localized(locales), cache.NewKey(req.FeatureID, req.TagIDs[0]), userID), nil
 }
 
-func (b *Banner) GetForAdmin(req *models.Banner, limit, offset int) ([]*models.Banner, error) {
-	banners, err := b.Repo.GetForAdmin(req, limit, offset)
+// GetForAdmin returns the banner versions matching the filter, a page of banners at a time.
+func (b *Banner) GetForAdmin(filter models.BannerFilter, page models.Page) (*models.BannerPage, error) {
+	result, err := b.Repo.GetForAdmin(filter, page)
 	if err != nil {
 		return nil, errs.WithMessage(err, "banners not found")
 	}
 
-	updatedIsActive, err := b.updateBannerIsActive(b.mergeBannerTags(banners))
+	result.Banners, err = b.updateBannerIsActive(b.mergeBannerTags(result.Banners))
 	if err != nil {
 		return nil, errs.WithMessage(err, "fail to update banner is active")
 	}
+	if err = b.attachNames(result.Banners); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// Search returns a page of banners whose content matches the text, best matches first.
+func (b *Banner) Search(text string, allVersions bool, page models.Page) (*models.SearchPage, error) {
+	result, err := b.Repo.Search(text, allVersions, page)
+	if err != nil {
+		return nil, errs.WithMessagef(err, "fail to search banners for: %q", text)
+	}
+
+	banners := make([]*models.Banner, len(result.Hits))
+	for i, hit := range result.Hits {
+		banners[i] = &hit.Banner
+	}
+	if err = b.attachNames(banners); err != nil {
+		return nil, err
+	}
 
-	return updatedIsActive, nil
+	return result, nil
 }
 
 func (b *Banner) Create(req *models.Banner) error {
+	if err := validateSchedule(req); err != nil {
+		return err
+	}
+	if err := b.checkCatalog(req); err != nil {
+		return err
+	}
+	if err := b.validateContents(req.FeatureID, bannerContents(req)); err != nil {
+		return err
+	}
+
 	_, err := b.Repo.CheckTagFeatureOverlap(req)
 	if err != nil {
 		if errs.Is(err, sql.ErrNoRows) {
@@ -72,28 +214,37 @@ func (b *Banner) Create(req *models.Banner) error {
 			if err != nil {
 				return errs.WithMessagef(err, "fail to create banner with id: %d", req.ID)
 			}
+			// drop pairs cached as not found
+			for _, tagID := range req.TagIDs {
+				b.Cache.Delete(cache.NewKey(req.FeatureID, tagID))
+			}
 			return nil
 		}
 		return errs.WithMessagef(err, "fail to execute CheckTagOverlap request with id: %d", req.ID)
 	}
 
-	return errs.Errorf("banner with tag: %d and feature: %d already exists", req.TagIDs, req.FeatureID)
+	return conflictErrorf("banner with tag: %d and feature: %d already exists", req.TagIDs, req.FeatureID)
 }
 
-func (b *Banner) Update(req *models.Banner) error {
-	bannerID, err := b.Repo.CheckTagFeatureOverlap(req)
-	if err != nil || bannerID == req.ID {
-		if errs.Is(err, sql.ErrNoRows) || bannerID == req.ID {
-			err = b.Repo.Update(req)
-			if err != nil {
-				return errs.WithMessagef(err, "fail to update banner with id: %d", req.ID)
-			}
-			return nil
-		}
-		return errs.WithMessagef(err, "fail to execute CheckTagOverlap request with id: %d", req.ID)
+// Update stores a new version of the banner and sets req.Revision to the new revision, a
+// non-zero expectedRevision guards against concurrent edits and must match the revision of
+// the banner. The schedule is checked again once merged with the stored one, an update may
+// set a single bound.
+func (b *Banner) Update(req *models.Banner, expectedRevision int) error {
+	if err := validateSchedule(req); err != nil {
+		return err
+	}
+	if err := b.checkCatalog(req); err != nil {
+		return err
+	}
+
+	err := b.Repo.Update(req, expectedRevision, b.validateMerged)
+	if err != nil {
+		return errs.WithMessagef(err, "fail to update banner with id: %d", req.ID)
 	}
+	b.evictBanner(req.ID)
 
-	return errs.Errorf("banner with tag: %d and feature: %d already exists and active", req.TagIDs, req.FeatureID)
+	return nil
 }
 
 func (b *Banner) Delete(bannerID int) error {
@@ -101,35 +252,122 @@ func (b *Banner) Delete(bannerID int) error {
 	if err != nil {
 		return errs.WithMessagef(err, "banner not found with bannerID: %d", bannerID)
 	}
+	b.evictBanner(bannerID)
 
 	return nil
 }
 
-func (b *Banner) SetVersionActive(bannerID, version int) error {
-	err := b.Repo.SetVersionActive(bannerID, version)
+// SetVersionActive activates the version and returns the new revision of the banner.
+func (b *Banner) SetVersionActive(bannerID, version, expectedRevision int) (int, error) {
+	revision, err := b.Repo.SetVersionActive(bannerID, version, expectedRevision)
 	if err != nil {
-		return errs.WithMessagef(err, "fail to set active version: %d for bannerID: %d", version, bannerID)
+		return 0, errs.WithMessagef(err, "fail to set active version: %d for bannerID: %d", version, bannerID)
+	}
+	b.evictBanner(bannerID)
+
+	return revision, nil
+}
+
+// SetLocale replaces the content of one locale of the version served to users without
+// creating a new version, nil content removes the locale. The content is checked against the
+// schema of the feature of that version like the default content. It returns the new
+// revision of the banner.
+func (b *Banner) SetLocale(bannerID int, locale string, content models.Content, expectedRevision int) (int, error) {
+	var check func(featureID int) error
+	if content != nil {
+		check = func(featureID int) error {
+			return b.validateContents(featureID, map[string]models.Content{"locales." + locale: content})
+		}
+	}
+
+	revision, err := b.Repo.SetLocaleContent(bannerID, locale, content, expectedRevision, check)
+	if err != nil {
+		return 0, errs.WithMessagef(err, "fail to set locale: %s for bannerID: %d", locale, bannerID)
+	}
+	b.evictBanner(bannerID)
+
+	return revision, nil
+}
+
+func (b *Banner) Stats() Stats {
+	stats := Stats{
+		Cache:       b.Cache.Stats(),
+		Coalesced:   b.coalesced.Load(),
+		Refreshes:   b.refreshes.Load(),
+		ServedStale: b.servedStale.Load(),
+	}
+	if b.Events != nil {
+		stats.Events = b.Events.Stats()
+	}
+
+	return stats
+}
+
+// Get returns the last version of the banner.
+func (b *Banner) Get(bannerID int) (*models.Banner, error) {
+	banner, err := b.Repo.GetVersion(bannerID, 0)
+	if err != nil {
+		return nil, errs.WithMessagef(err, "fail to get bannerID: %d", bannerID)
+	}
+	if err = b.attachNames([]*models.Banner{banner}); err != nil {
+		return nil, err
+	}
+
+	return banner, nil
+}
+
+func (b *Banner) GetVersions(bannerID int) ([]*models.Banner, error) {
+	versions, err := b.Repo.GetVersions(bannerID)
+	if err != nil {
+		return nil, errs.WithMessagef(err, "fail to get versions for bannerID: %d", bannerID)
+	}
+	if err = b.attachNames(versions); err != nil {
+		return nil, err
+	}
+
+	return versions, nil
+}
+
+func (b *Banner) Diff(bannerID, from, to int) (*models.BannerDiff, error) {
+	fromVersion, err := b.Repo.GetVersion(bannerID, from)
+	if err != nil {
+		return nil, errs.WithMessagef(err, "fail to get version: %d for bannerID: %d", from, bannerID)
+	}
+
+	toVersion, err := b.Repo.GetVersion(bannerID, to)
+	if err != nil {
+		return nil, errs.WithMessagef(err, "fail to get version: %d for bannerID: %d", to, bannerID)
+	}
+
+	return diffVersions(fromVersion, toVersion), nil
+}
+
+// validateSchedule checks the activity window, an open bound means the banner
+// is shown from the beginning or until the end of time.
+func validateSchedule(req *models.Banner) error {
+	if req.StartsAt != nil && req.EndsAt != nil && !req.EndsAt.After(*req.StartsAt) {
+		return validationErrorf("banner ends_at: %s must be after starts_at: %s",
+			req.EndsAt.Format(time.RFC3339), req.StartsAt.Format(time.RFC3339))
 	}
 
 	return nil
 }
 
+// mergeBannerTags folds the tag rows of every banner version into one banner, keeping
+// the order of the rows.
 func (b *Banner) mergeBannerTags(banners []*models.Banner) []*models.Banner {
 	mergedBanners := make(map[string]*models.Banner)
+	mergedBannersList := make([]*models.Banner, 0, len(banners))
 	for _, banner := range banners {
 		key := fmt.Sprintf("%d_%d_%d", banner.ID, banner.Version, banner.FeatureID)
 		if existingBanner, found := mergedBanners[key]; found {
 			existingBanner.TagIDs = append(existingBanner.TagIDs, banner.TagIDs...)
 		} else {
 			mergedBanners[key] = banner
+			mergedBannersList = append(mergedBannersList, banner)
 		}
 	}
 
-	mergedBannersList := make([]*models.Banner, 0, len(mergedBanners))
-	for _, banner := range mergedBanners {
-		mergedBannersList = append(mergedBannersList, banner)
-	}
-
 	return mergedBannersList
 }
 
@@ -3,15 +3,18 @@ package banner
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"fmt"
 	"github.com/golang/mock/gomock"
 	"github.com/mashmorsik/banners-service/config"
 	"github.com/mashmorsik/banners-service/infrastructure/data/cache"
 	"github.com/mashmorsik/banners-service/pkg/models"
+	"github.com/mashmorsik/banners-service/repository"
 	mock_repository "github.com/mashmorsik/banners-service/testdata/mock_repo"
 	"github.com/mashmorsik/logger"
 	errs "github.com/pkg/errors"
 	"reflect"
-	"strconv"
+	"sync"
 	"testing"
 	"time"
 )
@@ -23,6 +26,7 @@ func TestBanner_GetForUser(t *testing.T) {
 	defer ctrl.Finish()
 
 	conf := config.Config{}
+	conf.Cache.HardTTL = time.Hour
 
 	ctx := context.Background()
 	bannerCache := cache.NewBannerCache(ctx, time.Hour, &conf)
@@ -31,14 +35,13 @@ func TestBanner_GetForUser(t *testing.T) {
 		TagIDs:    []int{2},
 		FeatureID: 4,
 		Content: models.Content{
-			Title: "test_1_title",
-			Text:  "test_1_text",
-			URL:   "test_1_url",
+			"title": "test_1_title",
+			"text":  "test_1_text",
+			"url":   "test_1_url",
 		},
 	}
 
-	cacheKey := strconv.Itoa(banner.FeatureID) + strconv.Itoa(banner.TagIDs[0])
-	bannerCache.Set(cacheKey, banner.Content)
+	bannerCache.Set(cache.NewKey(banner.FeatureID, banner.TagIDs[0]), banner.Content)
 
 	mockRepo := mock_repository.NewMockRepository(ctrl)
 	mockRepo.EXPECT().GetForUser(&models.Banner{
@@ -63,9 +66,9 @@ func TestBanner_GetForUser(t *testing.T) {
 				FeatureID: 4,
 			}},
 			want: &models.Content{
-				Title: "test_1_title",
-				Text:  "test_1_text",
-				URL:   "test_1_url",
+				"title": "test_1_title",
+				"text":  "test_1_text",
+				"url":   "test_1_url",
 			},
 			wantErr: false,
 		},
@@ -85,20 +88,396 @@ func TestBanner_GetForUser(t *testing.T) {
 				Ctx:    ctx,
 				Repo:   mockRepo,
 				Config: &conf,
-				Cache:  &bannerCache,
+				Cache:  bannerCache,
 			}
-			got, err := b.GetForUser(tt.args.req)
+			got, err := b.GetForUser(tt.args.req, nil, "")
 			if (err != nil) != tt.wantErr {
 				t.Errorf("GetForUser() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
-			if !reflect.DeepEqual(got, tt.want) {
+			if err == nil && !reflect.DeepEqual(&got.Content, tt.want) {
+				t.Errorf("GetForUser() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBanner_GetForUserLocale(t *testing.T) {
+	logger.BuildLogger(nil)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	conf := config.Config{}
+	conf.Cache.HardTTL = time.Hour
+
+	ctx := context.Background()
+	bannerCache := cache.NewBannerCache(ctx, 0, &conf)
+
+	req := &models.Banner{FeatureID: 3, TagIDs: []int{8}}
+	mockRepo := mock_repository.NewMockRepository(ctrl)
+	mockRepo.EXPECT().GetForUser(req).Return(&models.Banner{
+		ID:        1,
+		FeatureID: 3,
+		TagIDs:    []int{8},
+		Content:   models.Content{"title": "default"},
+		Locales: map[string]models.Content{
+			"ru": {"title": "русский"},
+			"en": {"title": "english"},
+		},
+	}, nil)
+	mockRepo.EXPECT().GetFeatureSchema(3, 0).Return(nil, sql.ErrNoRows)
+	// the content is checked against the schema of the feature of the edited version
+	mockRepo.EXPECT().SetLocaleContent(1, "kk", models.Content{"title": "қазақ"}, 0, gomock.Any()).DoAndReturn(
+		func(bannerID int, locale string, content models.Content, expectedRevision int,
+			check func(featureID int) error) (int, error) {
+			return 2, check(3)
+		})
+	// removing a locale passes nil content and checks nothing
+	mockRepo.EXPECT().SetLocaleContent(1, "ru", models.Content(nil), 2, gomock.Nil()).Return(3, nil)
+	mockRepo.EXPECT().GetFeatureTags(1).Return([]models.FeatureTag{{FeatureID: 3, TagID: 8}}, nil).Times(2)
+
+	b := &Banner{
+		Ctx:    ctx,
+		Repo:   mockRepo,
+		Config: &conf,
+		Cache:  bannerCache,
+	}
+
+	tests := []struct {
+		name    string
+		locales []string
+		want    string
+	}{
+		{name: "first_locale_of_chain", locales: []string{"kk", "ru", "en"}, want: "русский"},
+		{name: "missing_locales_fall_back_to_default", locales: []string{"de-at", "de"}, want: "default"},
+		{name: "no_locale_is_default", locales: nil, want: "default"},
+		{name: "cached_with_all_locales", locales: []string{"en"}, want: "english"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := b.GetForUser(req, tt.locales, "")
+			if err != nil {
+				t.Fatalf("GetForUser() error = %v", err)
+			}
+			if got.Content["title"] != tt.want {
+				t.Errorf("GetForUser() got = %v, want title %s", got, tt.want)
+			}
+		})
+	}
+
+	// the pair is cached once whatever locales the clients ask for
+	if entries := bannerCache.Stats().Entries; entries != 1 {
+		t.Errorf("cache entries = %d, want 1", entries)
+	}
+	if _, err := b.SetLocale(1, "kk", models.Content{"title": "қазақ"}, 0); err != nil {
+		t.Fatalf("SetLocale() error = %v", err)
+	}
+	if entries := bannerCache.Stats().Entries; entries != 0 {
+		t.Errorf("cache entries after SetLocale() = %d, want 0", entries)
+	}
+	if revision, err := b.SetLocale(1, "ru", nil, 2); err != nil || revision != 3 {
+		t.Errorf("SetLocale() to remove a locale = %d, %v, want revision 3", revision, err)
+	}
+}
+
+func TestBanner_GetForUserVariants(t *testing.T) {
+	logger.BuildLogger(nil)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	conf := config.Config{}
+	conf.Cache.HardTTL = time.Hour
+
+	ctx := context.Background()
+	bannerCache := cache.NewBannerCache(ctx, 0, &conf)
+
+	req := &models.Banner{FeatureID: 2, TagIDs: []int{4}}
+	mockRepo := mock_repository.NewMockRepository(ctrl)
+	mockRepo.EXPECT().GetForUser(req).Return(&models.Banner{
+		ID:        1,
+		FeatureID: 2,
+		TagIDs:    []int{4},
+		Content:   models.Content{"title": "default"},
+		Variants: []models.Variant{
+			{Key: "a", Weight: 1, Content: models.Content{"title": "a"}, Locales: map[string]models.Content{
+				"ru": {"title": "а"},
+			}},
+			{Key: "paused", Weight: 0, Content: models.Content{"title": "paused"}},
+			{Key: "b", Weight: 1, Content: models.Content{"title": "b"}},
+		},
+	}, nil)
+
+	b := &Banner{
+		Ctx:    ctx,
+		Repo:   mockRepo,
+		Config: &conf,
+		Cache:  bannerCache,
+	}
+
+	got, err := b.GetForUser(req, nil, "")
+	if err != nil {
+		t.Fatalf("GetForUser() error = %v", err)
+	}
+	if got.Variant != "" || got.Content["title"] != "default" {
+		t.Errorf("GetForUser() without user got = %+v, want default content", got)
+	}
+
+	served := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		userID := fmt.Sprintf("user-%d", i)
+		got, err = b.GetForUser(req, nil, userID)
+		if err != nil {
+			t.Fatalf("GetForUser() error = %v", err)
+		}
+		if got.Content["title"] != got.Variant {
+			t.Fatalf("GetForUser() got = %+v", got)
+		}
+		if again, _ := b.GetForUser(req, nil, userID); again.Variant != got.Variant {
+			t.Fatalf("GetForUser() for %s got variant %q, then %q", userID, got.Variant, again.Variant)
+		}
+		served[got.Variant]++
+	}
+	if served["paused"] != 0 || served["a"] < 400 || served["b"] < 400 {
+		t.Errorf("GetForUser() served variants = %v, want a and b evenly", served)
+	}
+
+	for i := 0; ; i++ {
+		userID := fmt.Sprintf("user-%d", i)
+		if assignVariant([]models.Variant{{Key: "a", Weight: 1}, {Key: "b", Weight: 1}}, 2, 4, userID).Key != "a" {
+			continue
+		}
+		got, err = b.GetForUser(req, []string{"ru"}, userID)
+		if err != nil {
+			t.Fatalf("GetForUser() error = %v", err)
+		}
+		if got.Variant != "a" || got.Content["title"] != "а" {
+			t.Errorf("GetForUser() in ru got = %+v, want localized variant a", got)
+		}
+		break
+	}
+}
+
+func TestBanner_GetForUserCoalescing(t *testing.T) {
+	logger.BuildLogger(nil)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	conf := config.Config{}
+	conf.Cache.HardTTL = time.Hour
+
+	ctx := context.Background()
+	bannerCache := cache.NewBannerCache(ctx, time.Hour, &conf)
+
+	const callers = 10
+	release := make(chan struct{})
+
+	mockRepo := mock_repository.NewMockRepository(ctrl)
+	mockRepo.EXPECT().GetForUser(gomock.Any()).DoAndReturn(func(req *models.Banner) (*models.Banner, error) {
+		<-release
+		return &models.Banner{FeatureID: 3, TagIDs: []int{8}, Content: models.Content{"title": "popular"}}, nil
+	}).Times(1)
+
+	b := &Banner{
+		Ctx:    ctx,
+		Repo:   mockRepo,
+		Config: &conf,
+		Cache:  bannerCache,
+	}
+
+	var wg sync.WaitGroup
+	results := make(chan *models.UserBanner, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			content, err := b.GetForUser(&models.Banner{FeatureID: 3, TagIDs: []int{8}}, nil, "")
+			if err != nil {
+				t.Errorf("GetForUser() error = %v", err)
+				return
+			}
+			results <- content
+		}()
+	}
+
+	// let every caller reach the in-flight query before it completes
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(results)
+
+	for content := range results {
+		if content.Content["title"] != "popular" {
+			t.Errorf("GetForUser() got = %v, want title popular", content)
+		}
+	}
+	if got := b.Stats().Coalesced; got != callers-1 {
+		t.Errorf("Stats().Coalesced got = %d, want %d", got, callers-1)
+	}
+}
+
+func TestBanner_GetForUserStale(t *testing.T) {
+	logger.BuildLogger(nil)
+
+	cached := models.Content{"title": "cached"}
+	reloaded := models.Content{"title": "reloaded"}
+
+	tests := []struct {
+		name      string
+		softTTL   time.Duration
+		hardTTL   time.Duration
+		cached    bool
+		repoErr   error
+		want      *models.Content
+		wantErr   bool
+		wantAfter models.Content
+	}{
+		{
+			name:      "soft_expired_served_and_refreshed",
+			softTTL:   time.Nanosecond,
+			hardTTL:   time.Hour,
+			cached:    true,
+			want:      &cached,
+			wantAfter: reloaded,
+		},
+		{
+			name:      "hard_expired_reloaded",
+			hardTTL:   time.Nanosecond,
+			cached:    true,
+			want:      &reloaded,
+			wantAfter: reloaded,
+		},
+		{
+			name:    "hard_expired_served_when_database_is_down",
+			hardTTL: time.Nanosecond,
+			cached:  true,
+			repoErr: errs.New("connection refused"),
+			want:    &cached,
+		},
+		{
+			name:    "hard_expired_not_served_when_banner_is_gone",
+			hardTTL: time.Nanosecond,
+			cached:  true,
+			repoErr: sql.ErrNoRows,
+			wantErr: true,
+		},
+		{
+			name:    "missing_not_served_when_database_is_down",
+			hardTTL: time.Hour,
+			repoErr: errs.New("connection refused"),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			conf := config.Config{}
+			conf.Cache.SoftTTL = tt.softTTL
+			conf.Cache.HardTTL = tt.hardTTL
+			conf.Cache.MaxStaleness = time.Hour
+
+			ctx := context.Background()
+			bannerCache := cache.NewBannerCache(ctx, 0, &conf)
+			key := cache.NewKey(5, 6)
+			if tt.cached {
+				bannerCache.Set(key, cached)
+			}
+
+			refreshed := make(chan struct{})
+			mockRepo := mock_repository.NewMockRepository(ctrl)
+			mockRepo.EXPECT().GetForUser(gomock.Any()).DoAndReturn(func(req *models.Banner) (*models.Banner, error) {
+				defer close(refreshed)
+				if tt.repoErr != nil {
+					return nil, tt.repoErr
+				}
+				return &models.Banner{FeatureID: 5, TagIDs: []int{6}, Content: reloaded}, nil
+			})
+
+			b := &Banner{
+				Ctx:    ctx,
+				Repo:   mockRepo,
+				Config: &conf,
+				Cache:  bannerCache,
+			}
+			got, err := b.GetForUser(&models.Banner{FeatureID: 5, TagIDs: []int{6}}, nil, "")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetForUser() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(&got.Content, tt.want) {
 				t.Errorf("GetForUser() got = %v, want %v", got, tt.want)
 			}
+
+			<-refreshed
+			if tt.wantAfter == nil {
+				return
+			}
+			after, _, ok := bannerCache.Lookup(key)
+			for i := 0; i < 100 && (!ok || !reflect.DeepEqual(after.Content, tt.wantAfter)); i++ {
+				time.Sleep(time.Millisecond)
+				after, _, ok = bannerCache.Lookup(key)
+			}
+			if !ok || !reflect.DeepEqual(after.Content, tt.wantAfter) {
+				t.Errorf("cached content after GetForUser() = %v, want %v", after, tt.wantAfter)
+			}
 		})
 	}
 }
 
+func TestBanner_GetForUserNotFound(t *testing.T) {
+	logger.BuildLogger(nil)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	conf := config.Config{}
+	conf.Cache.HardTTL = time.Hour
+	conf.Cache.NegativeTTL = time.Minute
+
+	ctx := context.Background()
+	bannerCache := cache.NewBannerCache(ctx, 0, &conf)
+
+	req := &models.Banner{FeatureID: 8, TagIDs: []int{9}}
+	created := &models.Banner{FeatureID: 8, TagIDs: []int{9}, IsActive: true, Content: models.Content{"title": "created"}}
+
+	mockRepo := mock_repository.NewMockRepository(ctrl)
+	gomock.InOrder(
+		mockRepo.EXPECT().GetForUser(req).Return(nil, errs.WithMessage(sql.ErrNoRows, "no banner")),
+		mockRepo.EXPECT().GetFeatureSchema(8, 0).Return(nil, sql.ErrNoRows),
+		mockRepo.EXPECT().CheckTagFeatureOverlap(created).Return(0, sql.ErrNoRows),
+		mockRepo.EXPECT().Create(created).Return(nil),
+		mockRepo.EXPECT().GetForUser(req).Return(created, nil),
+	)
+
+	b := &Banner{
+		Ctx:    ctx,
+		Repo:   mockRepo,
+		Config: &conf,
+		Cache:  bannerCache,
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := b.GetForUser(req, nil, ""); !errs.Is(err, sql.ErrNoRows) {
+			t.Fatalf("GetForUser() call %d error = %v, want sql.ErrNoRows", i+1, err)
+		}
+	}
+
+	if err := b.Create(created); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	got, err := b.GetForUser(req, nil, "")
+	if err != nil {
+		t.Fatalf("GetForUser() after Create() error = %v", err)
+	}
+	if got.Content["title"] != "created" {
+		t.Errorf("GetForUser() after Create() got = %v, want title created", got)
+	}
+}
+
 func TestBanner_Create(t *testing.T) {
 	logger.BuildLogger(nil)
 
@@ -107,9 +486,9 @@ func TestBanner_Create(t *testing.T) {
 		FeatureID: 1,
 		IsActive:  true,
 		Content: models.Content{
-			Title: "test_create_title",
-			Text:  "test_create_text",
-			URL:   "test_create_url",
+			"title": "test_create_title",
+			"text":  "test_create_text",
+			"url":   "test_create_url",
 		},
 		CreatedAt: time.Time{},
 		UpdatedAt: time.Time{},
@@ -123,19 +502,59 @@ func TestBanner_Create(t *testing.T) {
 	ctx := context.Background()
 	bannerCache := cache.NewBannerCache(ctx, time.Hour, &conf)
 
+	matching := &models.Banner{
+		TagIDs:    []int{10},
+		FeatureID: 2,
+		Content:   models.Content{"title": "sale", "buttons": []any{map[string]any{"label": "buy"}}},
+	}
+	violating := &models.Banner{
+		TagIDs:    []int{11},
+		FeatureID: 2,
+		Content:   models.Content{"title": "summer sale", "buttons": []any{map[string]any{}}, "color": "red"},
+	}
+	violatingVariant := &models.Banner{
+		TagIDs:    []int{12},
+		FeatureID: 2,
+		Content:   models.Content{"title": "sale"},
+		Locales:   map[string]models.Content{"ru": {"title": "распродажа"}},
+		Variants: []models.Variant{
+			{Key: "a", Weight: 1, Content: models.Content{"title": "sale"}},
+			{Key: "b", Weight: 1, Content: models.Content{}, Locales: map[string]models.Content{
+				"kk": {"title": "sale", "color": "red"},
+			}},
+		},
+	}
+
 	mockRepo := mock_repository.NewMockRepository(ctrl)
+	mockRepo.EXPECT().GetFeatureSchema(1, 0).Return(nil, errs.WithMessage(sql.ErrNoRows, "no schema"))
 	mockRepo.EXPECT().CheckTagFeatureOverlap(banner).Return(0, sql.ErrNoRows)
 	mockRepo.EXPECT().Create(banner).Return(nil)
+	mockRepo.EXPECT().GetFeatureSchema(2, 0).Return(&models.FeatureSchema{
+		FeatureID: 2,
+		Version:   3,
+		Schema: json.RawMessage(`{
+			"type": "object",
+			"required": ["title"],
+			"additionalProperties": false,
+			"properties": {
+				"title": {"type": "string", "maxLength": 8},
+				"buttons": {"type": "array", "items": {"type": "object", "required": ["label"]}}
+			}
+		}`),
+	}, nil).Times(3)
+	mockRepo.EXPECT().CheckTagFeatureOverlap(matching).Return(0, sql.ErrNoRows)
+	mockRepo.EXPECT().Create(matching).Return(nil)
 
 	type args struct {
 		req *models.Banner
 	}
 
 	tests := []struct {
-		name    string
-		args    args
-		want    *models.Content
-		wantErr bool
+		name       string
+		args       args
+		want       *models.Content
+		wantErr    bool
+		wantFields []models.FieldError
 	}{
 		{
 			name:    "create_banner",
@@ -143,6 +562,31 @@ func TestBanner_Create(t *testing.T) {
 			want:    nil,
 			wantErr: false,
 		},
+		{
+			name:    "create_banner_matching_schema",
+			args:    args{req: matching},
+			wantErr: false,
+		},
+		{
+			name:    "create_banner_violating_schema",
+			args:    args{req: violating},
+			wantErr: true,
+			wantFields: []models.FieldError{
+				{Field: "content.buttons.label", Message: "is required"},
+				{Field: "content.color", Message: "is a forbidden property"},
+				{Field: "content.title", Message: "should be at most 8 chars long"},
+			},
+		},
+		{
+			name:    "create_banner_variant_violating_schema",
+			args:    args{req: violatingVariant},
+			wantErr: true,
+			wantFields: []models.FieldError{
+				{Field: "locales.ru.title", Message: "should be at most 8 chars long"},
+				{Field: "variants[1].content.title", Message: "is required"},
+				{Field: "variants[1].locales.kk.color", Message: "is a forbidden property"},
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -150,13 +594,74 @@ func TestBanner_Create(t *testing.T) {
 				Ctx:    ctx,
 				Repo:   mockRepo,
 				Config: &conf,
-				Cache:  &bannerCache,
+				Cache:  bannerCache,
 			}
 			err := b.Create(tt.args.req)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("GetForUser() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
+			var bannerErr *Error
+			var fields []models.FieldError
+			if errs.As(err, &bannerErr) {
+				fields = bannerErr.Fields
+			}
+			if tt.wantFields != nil && !reflect.DeepEqual(fields, tt.wantFields) {
+				t.Errorf("Create() error fields = %+v, want %+v", fields, tt.wantFields)
+			}
+		})
+	}
+}
+
+func TestValidateSchedule(t *testing.T) {
+	starts := time.Date(2024, 4, 10, 0, 0, 0, 0, time.UTC)
+	ends := starts.Add(time.Hour)
+
+	tests := []struct {
+		name     string
+		startsAt *time.Time
+		endsAt   *time.Time
+		wantErr  bool
+	}{
+		{
+			name:    "open_window",
+			wantErr: false,
+		},
+		{
+			name:     "starts_only",
+			startsAt: &starts,
+			wantErr:  false,
+		},
+		{
+			name:    "ends_only",
+			endsAt:  &ends,
+			wantErr: false,
+		},
+		{
+			name:     "ends_after_starts",
+			startsAt: &starts,
+			endsAt:   &ends,
+			wantErr:  false,
+		},
+		{
+			name:     "ends_at_starts",
+			startsAt: &starts,
+			endsAt:   &starts,
+			wantErr:  true,
+		},
+		{
+			name:     "ends_before_starts",
+			startsAt: &ends,
+			endsAt:   &starts,
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateSchedule(&models.Banner{StartsAt: tt.startsAt, EndsAt: tt.endsAt})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateSchedule() error = %v, wantErr %v", err, tt.wantErr)
+			}
 		})
 	}
 }
@@ -174,6 +679,7 @@ func TestBanner_Delete(t *testing.T) {
 
 	mockRepo := mock_repository.NewMockRepository(ctrl)
 	mockRepo.EXPECT().Delete(2).Return(nil)
+	mockRepo.EXPECT().GetFeatureTags(2).Return(nil, nil)
 	mockRepo.EXPECT().Delete(3).Return(errs.New("banner not found"))
 
 	tests := []struct {
@@ -198,7 +704,7 @@ func TestBanner_Delete(t *testing.T) {
 				Ctx:    ctx,
 				Repo:   mockRepo,
 				Config: &conf,
-				Cache:  &bannerCache,
+				Cache:  bannerCache,
 			}
 			err := b.Delete(tt.bannerID)
 			if (err != nil) != tt.wantErr {
@@ -208,3 +714,1123 @@ func TestBanner_Delete(t *testing.T) {
 		})
 	}
 }
+
+func TestBanner_Diff(t *testing.T) {
+	logger.BuildLogger(nil)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	conf := config.Config{}
+
+	ctx := context.Background()
+	bannerCache := cache.NewBannerCache(ctx, time.Hour, &conf)
+
+	mockRepo := mock_repository.NewMockRepository(ctrl)
+	mockRepo.EXPECT().GetVersion(1, 2).Return(&models.Banner{
+		ID:        1,
+		Version:   2,
+		TagIDs:    []int{1, 2, 3},
+		FeatureID: 4,
+		Content: models.Content{
+			"title": "old_title",
+			"text":  "same_text",
+			"url":   "old_url",
+		},
+	}, nil).Times(2)
+	mockRepo.EXPECT().GetVersion(1, 5).Return(&models.Banner{
+		ID:        1,
+		Version:   5,
+		TagIDs:    []int{2, 7},
+		FeatureID: 6,
+		Content: models.Content{
+			"title": "new_title",
+			"text":  "same_text",
+			"url":   "old_url",
+		},
+	}, nil)
+	mockRepo.EXPECT().GetVersion(1, 5).Return(&models.Banner{
+		ID:        1,
+		Version:   5,
+		TagIDs:    []int{2, 7},
+		FeatureID: 6,
+		Content: models.Content{
+			"title":  "new_title",
+			"text":   "same_text",
+			"url":    "old_url",
+			"colors": map[string]any{"text": "#000", "background": "#fff"},
+		},
+	}, nil)
+	mockRepo.EXPECT().GetVersion(1, 6).Return(&models.Banner{
+		ID:        1,
+		Version:   6,
+		TagIDs:    []int{2, 7},
+		FeatureID: 6,
+		Content: models.Content{
+			"title":   "new_title",
+			"url":     "old_url",
+			"colors":  map[string]any{"text": "#000", "background": "#eee"},
+			"buttons": []any{"buy"},
+		},
+	}, nil)
+	mockRepo.EXPECT().GetVersion(1, 7).Return(&models.Banner{
+		ID:        1,
+		Version:   7,
+		TagIDs:    []int{2},
+		FeatureID: 6,
+		Content:   models.Content{"title": "title"},
+		Locales: map[string]models.Content{
+			"ru": {"title": "старый", "text": "текст"},
+			"kk": {"title": "ескі"},
+		},
+	}, nil)
+	mockRepo.EXPECT().GetVersion(1, 8).Return(&models.Banner{
+		ID:        1,
+		Version:   8,
+		TagIDs:    []int{2},
+		FeatureID: 6,
+		Content:   models.Content{"title": "title"},
+		Locales: map[string]models.Content{
+			"ru": {"title": "новый", "text": "текст"},
+			"en": {"title": "new"},
+		},
+	}, nil)
+	mockRepo.EXPECT().GetVersion(1, 9).Return(nil, errs.Wrap(sql.ErrNoRows, "version 9 not found"))
+	mockRepo.EXPECT().GetVersion(1, 12).Return(&models.Banner{
+		ID:        1,
+		Version:   12,
+		TagIDs:    []int{2},
+		FeatureID: 6,
+		Content:   models.Content{"title": "title"},
+	}, nil).Times(2)
+	mockRepo.EXPECT().GetVersion(1, 13).Return(&models.Banner{
+		ID:        1,
+		Version:   13,
+		TagIDs:    []int{2},
+		FeatureID: 6,
+		Content:   models.Content{"title": "title"},
+		Locales:   map[string]models.Content{"en": {"title": "new"}, "ru": {"title": "новый"}},
+	}, nil).Times(2)
+	mockRepo.EXPECT().GetVersion(1, 10).Return(&models.Banner{
+		ID:        1,
+		Version:   10,
+		TagIDs:    []int{2},
+		FeatureID: 6,
+		Content:   models.Content{"title": "title"},
+		Variants:  []models.Variant{{Key: "a", Weight: 50}, {Key: "b", Weight: 50}},
+	}, nil)
+	mockRepo.EXPECT().GetVersion(1, 11).Return(&models.Banner{
+		ID:        1,
+		Version:   11,
+		TagIDs:    []int{2},
+		FeatureID: 6,
+		Content:   models.Content{"title": "title"},
+		Variants:  []models.Variant{{Key: "a", Weight: 20}, {Key: "b", Weight: 80}},
+	}, nil)
+
+	tests := []struct {
+		name    string
+		from    int
+		to      int
+		want    *models.BannerDiff
+		wantErr bool
+	}{
+		{
+			name: "diff_banner_versions_success",
+			from: 2,
+			to:   5,
+			want: &models.BannerDiff{
+				BannerID:      1,
+				From:          2,
+				To:            5,
+				AddedTagIDs:   []int{7},
+				RemovedTagIDs: []int{1, 3},
+				Patch: []models.PatchOp{
+					{Op: "replace", Path: "/content/title", Value: "new_title"},
+					{Op: "replace", Path: "/feature_id", Value: 6},
+					{Op: "remove", Path: "/tag_ids/2"},
+					{Op: "remove", Path: "/tag_ids/0"},
+					{Op: "add", Path: "/tag_ids/-", Value: 7},
+				},
+				Summary: []string{
+					`title changed from "old_title" to "new_title"`,
+					"feature_id changed from 4 to 6",
+					"tag_ids added: [7]",
+					"tag_ids removed: [1 3]",
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "diff_free_form_content",
+			from: 5,
+			to:   6,
+			want: &models.BannerDiff{
+				BannerID:      1,
+				From:          5,
+				To:            6,
+				AddedTagIDs:   []int{},
+				RemovedTagIDs: []int{},
+				Patch: []models.PatchOp{
+					{Op: "add", Path: "/content/buttons", Value: []any{"buy"}},
+					{Op: "replace", Path: "/content/colors/background", Value: "#eee"},
+					{Op: "remove", Path: "/content/text"},
+				},
+				Summary: []string{
+					`buttons added: ["buy"]`,
+					`colors.background changed from "#fff" to "#eee"`,
+					"text removed",
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "diff_locales",
+			from: 7,
+			to:   8,
+			want: &models.BannerDiff{
+				BannerID:      1,
+				From:          7,
+				To:            8,
+				AddedTagIDs:   []int{},
+				RemovedTagIDs: []int{},
+				Patch: []models.PatchOp{
+					{Op: "add", Path: "/locales/en", Value: map[string]any{"title": "new"}},
+					{Op: "remove", Path: "/locales/kk"},
+					{Op: "replace", Path: "/locales/ru/title", Value: "новый"},
+				},
+				Summary: []string{
+					`locales.en added: {"title":"new"}`,
+					"locales.kk removed",
+					`locales.ru.title changed from "старый" to "новый"`,
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "diff_first_locales",
+			from: 12,
+			to:   13,
+			want: &models.BannerDiff{
+				BannerID:      1,
+				From:          12,
+				To:            13,
+				AddedTagIDs:   []int{},
+				RemovedTagIDs: []int{},
+				Patch: []models.PatchOp{
+					{Op: "add", Path: "/locales", Value: map[string]models.Content{
+						"en": {"title": "new"},
+						"ru": {"title": "новый"},
+					}},
+				},
+				Summary: []string{
+					`locales.en added: {"title":"new"}`,
+					`locales.ru added: {"title":"новый"}`,
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "diff_last_locales_removed",
+			from: 13,
+			to:   12,
+			want: &models.BannerDiff{
+				BannerID:      1,
+				From:          13,
+				To:            12,
+				AddedTagIDs:   []int{},
+				RemovedTagIDs: []int{},
+				Patch:         []models.PatchOp{{Op: "remove", Path: "/locales"}},
+				Summary:       []string{"locales.en removed", "locales.ru removed"},
+			},
+			wantErr: false,
+		},
+		{
+			name: "diff_variants",
+			from: 10,
+			to:   11,
+			want: &models.BannerDiff{
+				BannerID:      1,
+				From:          10,
+				To:            11,
+				AddedTagIDs:   []int{},
+				RemovedTagIDs: []int{},
+				Patch: []models.PatchOp{
+					{Op: "replace", Path: "/variants", Value: []models.Variant{{Key: "a", Weight: 20}, {Key: "b", Weight: 80}}},
+				},
+				Summary: []string{"variants changed to [a:20 b:80]"},
+			},
+			wantErr: false,
+		},
+		{
+			name:    "diff_banner_versions_fail",
+			from:    2,
+			to:      9,
+			want:    nil,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &Banner{
+				Ctx:    ctx,
+				Repo:   mockRepo,
+				Config: &conf,
+				Cache:  bannerCache,
+			}
+			got, err := b.Diff(1, tt.from, tt.to)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Diff() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Diff() got = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBanner_GetVersions(t *testing.T) {
+	logger.BuildLogger(nil)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	conf := config.Config{}
+
+	mockRepo := mock_repository.NewMockRepository(ctrl)
+	mockRepo.EXPECT().GetVersions(1).Return([]*models.Banner{
+		{ID: 1, Version: 2, FeatureID: 4, TagIDs: []int{2}, IsActive: true},
+		{ID: 1, Version: 1, FeatureID: 4, TagIDs: []int{1, 2}},
+	}, nil)
+	mockRepo.EXPECT().ListCatalog(models.CatalogFeatures, []int{4}, true).Return([]*models.CatalogEntry{
+		{ID: 4, Name: "checkout"},
+	}, nil)
+	mockRepo.EXPECT().ListCatalog(models.CatalogTags, []int{1, 2}, true).Return([]*models.CatalogEntry{
+		{ID: 1, Name: "new users"},
+		{ID: 2, Name: "premium"},
+	}, nil)
+	mockRepo.EXPECT().GetVersions(2).Return(nil, errs.WithMessage(sql.ErrNoRows, "no versions found for banner 2"))
+	mockRepo.EXPECT().GetVersions(3).Return(nil, errs.New("connection refused"))
+
+	tests := []struct {
+		name     string
+		bannerID int
+		want     []*models.Banner
+		wantErr  bool
+		wantKind ErrorKind
+	}{
+		{
+			name:     "get_versions_success",
+			bannerID: 1,
+			want: []*models.Banner{
+				{ID: 1, Version: 2, FeatureID: 4, FeatureName: "checkout", TagIDs: []int{2},
+					TagNames: []string{"premium"}, IsActive: true},
+				{ID: 1, Version: 1, FeatureID: 4, FeatureName: "checkout", TagIDs: []int{1, 2},
+					TagNames: []string{"new users", "premium"}},
+			},
+			wantErr: false,
+		},
+		{
+			name:     "get_versions_unknown_banner",
+			bannerID: 2,
+			wantErr:  true,
+			wantKind: KindNotFound,
+		},
+		{
+			name:     "get_versions_repo_error",
+			bannerID: 3,
+			wantErr:  true,
+			wantKind: KindInternal,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &Banner{
+				Ctx:    context.Background(),
+				Repo:   mockRepo,
+				Config: &conf,
+			}
+			got, err := b.GetVersions(tt.bannerID)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GetVersions() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil && KindOf(err) != tt.wantKind {
+				t.Errorf("GetVersions() error kind = %v, want %v", KindOf(err), tt.wantKind)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetVersions() got = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBanner_Prune(t *testing.T) {
+	logger.BuildLogger(nil)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	ctx := context.Background()
+
+	mockRepo := mock_repository.NewMockRepository(ctrl)
+	mockRepo.EXPECT().GetVersion(3, 0).Return(&models.Banner{ID: 3}, nil).Times(2)
+	mockRepo.EXPECT().GetVersion(4, 0).Return(nil, errs.WithMessage(sql.ErrNoRows, "version 0 not found for banner 4"))
+	mockRepo.EXPECT().PruneVersions(3, 5, time.Time{}).Return(7, nil)
+
+	tests := []struct {
+		name     string
+		bannerID int
+		keepLast int
+		want     int
+		wantErr  bool
+	}{
+		{
+			name:     "prune_banner_keep_last",
+			bannerID: 3,
+			keepLast: 5,
+			want:     7,
+			wantErr:  false,
+		},
+		{
+			name:     "prune_banner_no_policy",
+			bannerID: 3,
+			keepLast: 0,
+			want:     0,
+			wantErr:  false,
+		},
+		{
+			name:     "prune_unknown_banner",
+			bannerID: 4,
+			keepLast: 5,
+			want:     0,
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := config.Config{}
+			conf.Retention.KeepLastVersions = tt.keepLast
+
+			b := &Banner{
+				Ctx:    ctx,
+				Repo:   mockRepo,
+				Config: &conf,
+			}
+			got, err := b.Prune(tt.bannerID)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Prune() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil && KindOf(err) != KindNotFound {
+				t.Errorf("Prune() error kind = %v, want %v", KindOf(err), KindNotFound)
+			}
+			if got != tt.want {
+				t.Errorf("Prune() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBanner_Restore(t *testing.T) {
+	logger.BuildLogger(nil)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	conf := config.Config{}
+	ctx := context.Background()
+	bannerCache := cache.NewBannerCache(ctx, time.Hour, &conf)
+
+	mockRepo := mock_repository.NewMockRepository(ctrl)
+	mockRepo.EXPECT().Restore(1).Return(nil)
+	mockRepo.EXPECT().GetFeatureTags(1).Return(nil, nil)
+	mockRepo.EXPECT().Restore(2).Return(errs.WithMessage(repository.ErrOverlap, "banner 5 is active"))
+	mockRepo.EXPECT().Restore(3).Return(errs.WithMessage(sql.ErrNoRows, "banner 3 not found in trash"))
+
+	tests := []struct {
+		name     string
+		bannerID int
+		wantErr  bool
+		wantKind ErrorKind
+	}{
+		{
+			name:     "restore_banner_success",
+			bannerID: 1,
+			wantErr:  false,
+		},
+		{
+			name:     "restore_banner_conflict",
+			bannerID: 2,
+			wantErr:  true,
+			wantKind: KindConflict,
+		},
+		{
+			name:     "restore_banner_not_in_trash",
+			bannerID: 3,
+			wantErr:  true,
+			wantKind: KindNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &Banner{
+				Ctx:    ctx,
+				Repo:   mockRepo,
+				Config: &conf,
+				Cache:  bannerCache,
+			}
+			err := b.Restore(tt.bannerID)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Restore() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil && KindOf(err) != tt.wantKind {
+				t.Errorf("Restore() error kind = %v, want %v", KindOf(err), tt.wantKind)
+			}
+		})
+	}
+}
+
+func TestBanner_BulkDelete(t *testing.T) {
+	logger.BuildLogger(nil)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	conf := config.Config{}
+	conf.Cache.HardTTL = time.Hour
+	conf.Jobs.BulkDeleteBatchSize = 2
+
+	ctx := context.Background()
+	bannerCache := cache.NewBannerCache(ctx, time.Hour, &conf)
+	bannerCache.Set(cache.NewKey(7, 1), models.Content{"title": "bulk_delete_title"})
+
+	finished := make(chan models.Job, 1)
+	var saved []models.JobStatus
+
+	mockRepo := mock_repository.NewMockRepository(ctrl)
+	gomock.InOrder(
+		mockRepo.EXPECT().DeleteBatch(7, 0, 2).Return(2, []models.FeatureTag{{FeatureID: 7, TagID: 1}}, nil),
+		mockRepo.EXPECT().DeleteBatch(7, 0, 2).Return(1, []models.FeatureTag{{FeatureID: 7, TagID: 2}}, nil),
+	)
+	mockRepo.EXPECT().SaveJob(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, j *models.Job) error {
+		saved = append(saved, j.Status)
+		if j.FinishedAt != nil {
+			finished <- *j
+		}
+		return nil
+	}).AnyTimes()
+
+	b := NewBanner(ctx, mockRepo, &conf, bannerCache)
+
+	if _, err := b.BulkDelete(0, 0); err == nil {
+		t.Errorf("BulkDelete() without filters error = nil, wantErr true")
+	}
+
+	started, err := b.BulkDelete(7, 0)
+	if err != nil {
+		t.Fatalf("BulkDelete() error = %v", err)
+	}
+
+	var got models.Job
+	select {
+	case got = <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("BulkDelete() job %s did not finish", started.ID)
+	}
+
+	if got.ID != started.ID || got.Status != models.JobStatusDone || got.Affected != 3 {
+		t.Errorf("BulkDelete() job = %+v, want status %s and 3 affected", got, models.JobStatusDone)
+	}
+	wantSaved := []models.JobStatus{
+		models.JobStatusPending, models.JobStatusRunning, models.JobStatusRunning, models.JobStatusRunning,
+		models.JobStatusDone,
+	}
+	if !reflect.DeepEqual(saved, wantSaved) {
+		t.Errorf("BulkDelete() saved job states = %v, want %v", saved, wantSaved)
+	}
+	if _, ok := bannerCache.Get(cache.NewKey(7, 1)); ok {
+		t.Errorf("BulkDelete() did not evict cache for feature 7 and tag 1")
+	}
+}
+
+func TestBanner_Update(t *testing.T) {
+	logger.BuildLogger(nil)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	conf := config.Config{}
+	ctx := context.Background()
+	bannerCache := cache.NewBannerCache(ctx, time.Hour, &conf)
+
+	banner := &models.Banner{ID: 4, TagIDs: []int{1}, FeatureID: 2}
+	storedStart := time.Date(2024, 4, 10, 0, 0, 0, 0, time.UTC)
+	endsBeforeStart := storedStart.Add(-time.Hour)
+
+	mockRepo := mock_repository.NewMockRepository(ctrl)
+	mockRepo.EXPECT().GetFeatureSchema(2, 0).Return(&models.FeatureSchema{
+		FeatureID: 2,
+		Version:   1,
+		Schema:    json.RawMessage(`{"type": "object", "properties": {"title": {"type": "string"}}}`),
+	}, nil)
+	mockRepo.EXPECT().Update(banner, 3, gomock.Any()).Return(nil)
+	mockRepo.EXPECT().GetFeatureTags(4).Return(nil, nil)
+	mockRepo.EXPECT().Update(banner, 2, gomock.Any()).
+		Return(errs.WithMessage(repository.ErrVersionMismatch, "banner 4 is at revision 3"))
+	// the request sets ends_at only, the merged banner keeps the stored starts_at
+	mockRepo.EXPECT().Update(banner, 5, gomock.Any()).DoAndReturn(
+		func(b *models.Banner, expectedRevision int, check func(*models.Banner) error) error {
+			merged := *b
+			merged.StartsAt, merged.EndsAt = &storedStart, &endsBeforeStart
+			return check(&merged)
+		})
+	// the kept locales of the last version are checked against the current schema too
+	mockRepo.EXPECT().Update(banner, 6, gomock.Any()).DoAndReturn(
+		func(b *models.Banner, expectedRevision int, check func(*models.Banner) error) error {
+			merged := *b
+			merged.Content = models.Content{"title": "default"}
+			merged.Locales = map[string]models.Content{"ru": {"title": json.Number("5")}}
+			return check(&merged)
+		})
+	mockRepo.EXPECT().Update(banner, 7, gomock.Any()).
+		Return(errs.WithMessage(repository.ErrOverlap, "banner 9 holds feature 2 and one of tags [1]"))
+
+	tests := []struct {
+		name             string
+		expectedRevision int
+		wantErr          error
+		wantKind         ErrorKind
+	}{
+		{
+			name:             "update_banner_success",
+			expectedRevision: 3,
+			wantErr:          nil,
+		},
+		{
+			name:             "update_banner_revision_mismatch",
+			expectedRevision: 2,
+			wantErr:          repository.ErrVersionMismatch,
+			wantKind:         KindConflict,
+		},
+		{
+			name:             "update_ends_before_stored_start",
+			expectedRevision: 5,
+			wantKind:         KindValidation,
+		},
+		{
+			name:             "kept_locale_does_not_match_schema",
+			expectedRevision: 6,
+			wantKind:         KindValidation,
+		},
+		{
+			name:             "merged_pair_held_by_active_banner",
+			expectedRevision: 7,
+			wantErr:          repository.ErrOverlap,
+			wantKind:         KindConflict,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &Banner{
+				Ctx:    ctx,
+				Repo:   mockRepo,
+				Config: &conf,
+				Cache:  bannerCache,
+			}
+			err := b.Update(banner, tt.expectedRevision)
+			if tt.wantErr != nil && !errs.Is(err, tt.wantErr) {
+				t.Errorf("Update() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if (err != nil) != (tt.wantKind != KindInternal) || (err != nil && KindOf(err) != tt.wantKind) {
+				t.Errorf("Update() error = %v, want kind %v", err, tt.wantKind)
+			}
+		})
+	}
+}
+
+func TestBanner_CacheInvalidation(t *testing.T) {
+	logger.BuildLogger(nil)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	conf := config.Config{}
+	conf.Cache.HardTTL = time.Hour
+
+	ctx := context.Background()
+	bannerCache := cache.NewBannerCache(ctx, time.Hour, &conf)
+
+	updated := &models.Banner{ID: 1, TagIDs: []int{12}, FeatureID: 10}
+
+	mockRepo := mock_repository.NewMockRepository(ctrl)
+	mockRepo.EXPECT().Update(updated, 0, gomock.Any()).Return(nil)
+	mockRepo.EXPECT().Delete(2).Return(nil)
+	mockRepo.EXPECT().SetVersionActive(3, 1, 0).Return(2, nil)
+	mockRepo.EXPECT().SetVersionActive(4, 2, 0).Return(3, nil)
+	mockRepo.EXPECT().GetFeatureTags(1).Return([]models.FeatureTag{
+		{FeatureID: 10, TagID: 11},
+		{FeatureID: 10, TagID: 12},
+	}, nil)
+	mockRepo.EXPECT().GetFeatureTags(2).Return([]models.FeatureTag{{FeatureID: 20, TagID: 21}}, nil)
+	mockRepo.EXPECT().GetFeatureTags(3).Return([]models.FeatureTag{{FeatureID: 30, TagID: 31}}, nil)
+	mockRepo.EXPECT().GetFeatureTags(4).Return(nil, errs.New("connection refused"))
+
+	tests := []struct {
+		name    string
+		write   func(b *Banner) error
+		evicted []cache.Key
+		flushed bool
+	}{
+		{
+			name:    "update_evicts_old_and_new_tags",
+			write:   func(b *Banner) error { return b.Update(updated, 0) },
+			evicted: []cache.Key{cache.NewKey(10, 11), cache.NewKey(10, 12)},
+		},
+		{
+			name:    "delete_evicts_banner_tags",
+			write:   func(b *Banner) error { return b.Delete(2) },
+			evicted: []cache.Key{cache.NewKey(20, 21)},
+		},
+		{
+			name:    "set_version_active_evicts_banner_tags",
+			write:   func(b *Banner) error { _, err := b.SetVersionActive(3, 1, 0); return err },
+			evicted: []cache.Key{cache.NewKey(30, 31)},
+		},
+		{
+			name:    "unknown_tags_flush_cache",
+			write:   func(b *Banner) error { _, err := b.SetVersionActive(4, 2, 0); return err },
+			evicted: []cache.Key{cache.NewKey(40, 41), cache.NewKey(50, 51)},
+			flushed: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, key := range tt.evicted {
+				bannerCache.Set(key, models.Content{"title": "evicted"})
+			}
+			bannerCache.Set(cache.NewKey(99, 99), models.Content{"title": "untouched"})
+
+			b := &Banner{
+				Ctx:    ctx,
+				Repo:   mockRepo,
+				Config: &conf,
+				Cache:  bannerCache,
+			}
+			if err := tt.write(b); err != nil {
+				t.Fatalf("write error = %v", err)
+			}
+
+			for _, key := range tt.evicted {
+				if _, ok := bannerCache.Get(key); ok {
+					t.Errorf("key %v is still cached", key)
+				}
+			}
+			if _, ok := bannerCache.Get(cache.NewKey(99, 99)); ok == tt.flushed {
+				t.Errorf("unrelated key cached = %v, want %v", ok, !tt.flushed)
+			}
+		})
+	}
+}
+
+func TestBanner_WarmUp(t *testing.T) {
+	logger.BuildLogger(nil)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	conf := config.Config{}
+	conf.Cache.HardTTL = time.Hour
+
+	ctx := context.Background()
+	ended := time.Now().Add(-time.Minute)
+
+	mockRepo := mock_repository.NewMockRepository(ctrl)
+	gomock.InOrder(
+		mockRepo.EXPECT().GetActiveContents(defaultWarmUpTimeout).Return([]*models.Banner{
+			{ID: 1, FeatureID: 1, TagIDs: []int{23}, Content: models.Content{"title": "first"},
+				Locales: map[string]models.Content{"ru": {"title": "первый"}}},
+			{ID: 2, FeatureID: 12, TagIDs: []int{3}, Content: models.Content{"title": "second"}},
+			{ID: 3, FeatureID: 5, TagIDs: []int{5}, Content: models.Content{"title": "ended"}, EndsAt: &ended},
+		}, nil),
+		mockRepo.EXPECT().GetActiveContents(defaultWarmUpTimeout).Return(nil, errs.New("timeout")),
+	)
+
+	tests := []struct {
+		name       string
+		want       int
+		wantErr    bool
+		wantCached map[cache.Key]string
+		// wantServedRu is served in ru from the warmed cache, without a database call
+		wantServedRu map[cache.Key]string
+	}{
+		{
+			name:         "warm_up_active_banners",
+			want:         3,
+			wantCached:   map[cache.Key]string{cache.NewKey(1, 23): "first", cache.NewKey(12, 3): "second"},
+			wantServedRu: map[cache.Key]string{cache.NewKey(1, 23): "первый", cache.NewKey(12, 3): "second"},
+		},
+		{
+			name:    "warm_up_failed",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bannerCache := cache.NewBannerCache(ctx, 0, &conf)
+			b := &Banner{
+				Ctx:    ctx,
+				Repo:   mockRepo,
+				Config: &conf,
+				Cache:  bannerCache,
+			}
+
+			got, err := b.WarmUp()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("WarmUp() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("WarmUp() got = %d, want %d", got, tt.want)
+			}
+
+			for key, title := range tt.wantCached {
+				content, ok := bannerCache.Get(key)
+				if !ok || (*content)["title"] != title {
+					t.Errorf("cached content for %v = %v, want title %s", key, content, title)
+				}
+			}
+			for key, title := range tt.wantServedRu {
+				req := &models.Banner{FeatureID: key.FeatureID, TagIDs: []int{key.TagID}}
+				served, err := b.GetForUser(req, []string{"ru"}, "")
+				if err != nil || served.Content["title"] != title {
+					t.Errorf("GetForUser() in ru for %v = %v, %v, want title %s", key, served, err, title)
+				}
+			}
+			if _, ok := bannerCache.Get(cache.NewKey(5, 5)); ok {
+				t.Errorf("content of the ended banner is cached")
+			}
+		})
+	}
+}
+
+func TestKindOf(t *testing.T) {
+	logger.BuildLogger(nil)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	conf := config.Config{}
+	starts := time.Now()
+	ends := starts.Add(-time.Hour)
+	duplicate := &models.Banner{FeatureID: 1, TagIDs: []int{2}}
+
+	mockRepo := mock_repository.NewMockRepository(ctrl)
+	mockRepo.EXPECT().GetFeatureSchema(1, 0).Return(nil, sql.ErrNoRows)
+	mockRepo.EXPECT().CheckTagFeatureOverlap(duplicate).Return(3, nil)
+
+	b := &Banner{Ctx: context.Background(), Repo: mockRepo, Config: &conf}
+
+	tests := []struct {
+		name string
+		err  error
+		want ErrorKind
+	}{
+		{
+			name: "not_found",
+			err:  errs.WithMessage(sql.ErrNoRows, "fail to get bannerID: 1"),
+			want: KindNotFound,
+		},
+		{
+			name: "version_mismatch",
+			err:  errs.WithMessage(repository.ErrVersionMismatch, "fail to update banner with id: 1"),
+			want: KindConflict,
+		},
+		{
+			name: "overlapping_banner",
+			err:  b.Create(duplicate),
+			want: KindConflict,
+		},
+		{
+			name: "invalid_schedule",
+			err:  b.Create(&models.Banner{StartsAt: &starts, EndsAt: &ends}),
+			want: KindValidation,
+		},
+		{
+			name: "schedule_rejected_by_database",
+			err:  errs.WithMessage(repository.ErrInvalidSchedule, "fail to update banner with id: 1"),
+			want: KindValidation,
+		},
+		{
+			name: "bulk_delete_without_filter",
+			err: func() error {
+				_, err := b.BulkDelete(0, 0)
+				return err
+			}(),
+			want: KindValidation,
+		},
+		{
+			name: "catalog_entry_in_use",
+			err:  errs.WithMessage(repository.ErrInUse, "tag 3 is used by banners"),
+			want: KindConflict,
+		},
+		{
+			name: "duplicate_catalog_entry",
+			err:  errs.WithMessage(repository.ErrAlreadyExists, "feature 3"),
+			want: KindConflict,
+		},
+		{
+			name: "database_failure",
+			err:  errs.New("connection refused"),
+			want: KindInternal,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := KindOf(tt.err); got != tt.want {
+				t.Errorf("KindOf(%v) got = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBanner_GetForAdmin(t *testing.T) {
+	logger.BuildLogger(nil)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	conf := config.Config{}
+	isActive := true
+	filter := models.BannerFilter{TagIDs: []int{1, 3}, TagMatch: models.TagMatchAny, IsActive: &isActive}
+	cursor := &models.Cursor{Sort: models.SortByID, ID: 7}
+	page := models.Page{Limit: 2, Cursor: cursor, Sort: models.Sort{Field: models.SortByID}}
+
+	mockRepo := mock_repository.NewMockRepository(ctrl)
+	mockRepo.EXPECT().GetForAdmin(filter, page).Return(&models.BannerPage{
+		Banners: []*models.Banner{
+			{ID: 5, Version: 1, FeatureID: 1, TagIDs: []int{1}},
+			{ID: 5, Version: 1, FeatureID: 1, TagIDs: []int{2}},
+			{ID: 5, Version: 2, FeatureID: 1, TagIDs: []int{3}},
+			{ID: 3, Version: 1, FeatureID: 2, TagIDs: []int{4}},
+		},
+		Total:      10,
+		NextCursor: &models.Cursor{ID: 3},
+	}, nil)
+	mockRepo.EXPECT().GetBannerActiveVersions().Return(map[int]int{5: 2, 3: 1}, nil)
+	mockRepo.EXPECT().ListCatalog(models.CatalogFeatures, []int{1, 2}, true).Return([]*models.CatalogEntry{
+		{ID: 1, Name: "checkout"},
+		{ID: 2, Name: "profile", Archived: true},
+	}, nil)
+	mockRepo.EXPECT().ListCatalog(models.CatalogTags, []int{1, 2, 3, 4}, true).Return([]*models.CatalogEntry{
+		{ID: 1, Name: "new users"},
+		{ID: 2, Name: "premium"},
+		{ID: 3, Name: "mobile"},
+	}, nil)
+
+	b := &Banner{Ctx: context.Background(), Repo: mockRepo, Config: &conf}
+
+	got, err := b.GetForAdmin(filter, page)
+	if err != nil {
+		t.Fatalf("GetForAdmin() error = %v", err)
+	}
+
+	want := &models.BannerPage{
+		Banners: []*models.Banner{
+			{ID: 5, Version: 1, FeatureID: 1, FeatureName: "checkout", TagIDs: []int{1, 2},
+				TagNames: []string{"new users", "premium"}},
+			{ID: 5, Version: 2, FeatureID: 1, FeatureName: "checkout", TagIDs: []int{3},
+				TagNames: []string{"mobile"}, IsActive: true},
+			{ID: 3, Version: 1, FeatureID: 2, FeatureName: "profile", TagIDs: []int{4},
+				TagNames: []string{""}, IsActive: true},
+		},
+		Total:      10,
+		NextCursor: &models.Cursor{ID: 3},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetForAdmin() got = %+v, want %+v", got, want)
+	}
+}
+
+func TestBanner_PutSchema(t *testing.T) {
+	logger.BuildLogger(nil)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	conf := config.Config{}
+	valid := json.RawMessage(`{
+		"type": "object",
+		"definitions": {"button": {"type": "object", "required": ["label"]}},
+		"properties": {"buttons": {"type": "array", "items": {"$ref": "#/definitions/button"}}}
+	}`)
+
+	mockRepo := mock_repository.NewMockRepository(ctrl)
+	mockRepo.EXPECT().PutFeatureSchema(3, valid).Return(&models.FeatureSchema{FeatureID: 3, Version: 2, Schema: valid}, nil)
+
+	b := &Banner{Ctx: context.Background(), Repo: mockRepo, Config: &conf}
+
+	tests := []struct {
+		name       string
+		schema     json.RawMessage
+		want       *models.FeatureSchema
+		wantFields []models.FieldError
+	}{
+		{
+			name:   "put_valid_schema",
+			schema: valid,
+			want:   &models.FeatureSchema{FeatureID: 3, Version: 2, Schema: valid},
+		},
+		{
+			name:       "schema_is_not_an_object",
+			schema:     json.RawMessage(`["object"]`),
+			wantFields: []models.FieldError{{Field: "schema", Message: "must be a JSON object"}},
+		},
+		{
+			name:   "schema_does_not_match_draft_4",
+			schema: json.RawMessage(`{"type": "object", "properties": {"title": {"maxLength": -1}}}`),
+			wantFields: []models.FieldError{
+				{Field: "schema.properties.title.maxLength", Message: "should be greater than or equal to 0"},
+			},
+		},
+		{
+			name:   "remote_reference",
+			schema: json.RawMessage(`{"properties": {"title": {"$ref": "http://example.com/title.json"}}}`),
+			wantFields: []models.FieldError{
+				{Field: "schema.properties.title.$ref", Message: `must point into the schema, got "http://example.com/title.json"`},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := b.PutSchema(3, tt.schema)
+
+			var bannerErr *Error
+			var fields []models.FieldError
+			if errs.As(err, &bannerErr) {
+				fields = bannerErr.Fields
+			}
+			if !reflect.DeepEqual(fields, tt.wantFields) {
+				t.Errorf("PutSchema() error = %v, fields = %+v, want %+v", err, fields, tt.wantFields)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("PutSchema() got = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBanner_StrictCatalog(t *testing.T) {
+	logger.BuildLogger(nil)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	conf := config.Config{}
+	conf.Catalog.Strict = true
+
+	unknown := &models.Banner{FeatureID: 7, TagIDs: []int{1, 2, 3}}
+	known := &models.Banner{FeatureID: 8, TagIDs: []int{1}}
+	retagged := &models.Banner{ID: 4, TagIDs: []int{2}}
+
+	mockRepo := mock_repository.NewMockRepository(ctrl)
+	mockRepo.EXPECT().ListCatalog(models.CatalogFeatures, []int{7}, true).Return([]*models.CatalogEntry{}, nil)
+	mockRepo.EXPECT().ListCatalog(models.CatalogTags, []int{1, 2, 3}, true).Return([]*models.CatalogEntry{
+		{ID: 1, Name: "new users"},
+		{ID: 3, Name: "legacy", Archived: true},
+	}, nil)
+	mockRepo.EXPECT().ListCatalog(models.CatalogFeatures, []int{8}, true).Return([]*models.CatalogEntry{{ID: 8}}, nil)
+	mockRepo.EXPECT().ListCatalog(models.CatalogTags, []int{1}, true).Return([]*models.CatalogEntry{{ID: 1}}, nil)
+	mockRepo.EXPECT().GetFeatureSchema(8, 0).Return(nil, sql.ErrNoRows)
+	mockRepo.EXPECT().CheckTagFeatureOverlap(known).Return(0, sql.ErrNoRows)
+	mockRepo.EXPECT().Create(known).Return(nil)
+	mockRepo.EXPECT().ListCatalog(models.CatalogTags, []int{2}, true).Return([]*models.CatalogEntry{}, nil)
+
+	bannerCache := cache.NewBannerCache(context.Background(), time.Hour, &conf)
+	b := &Banner{Ctx: context.Background(), Repo: mockRepo, Config: &conf, Cache: bannerCache}
+
+	tests := []struct {
+		name       string
+		call       func() error
+		wantFields []models.FieldError
+	}{
+		{
+			name: "create_with_unknown_feature_and_tags",
+			call: func() error { return b.Create(unknown) },
+			wantFields: []models.FieldError{
+				{Field: "feature_id", Message: "is not in the catalog"},
+				{Field: "tag_ids[1]", Message: "is not in the catalog"},
+				{Field: "tag_ids[2]", Message: "is archived"},
+			},
+		},
+		{
+			name: "create_with_known_feature_and_tags",
+			call: func() error { return b.Create(known) },
+		},
+		{
+			name: "update_checks_set_fields_only",
+			call: func() error { return b.Update(retagged, 0) },
+			wantFields: []models.FieldError{
+				{Field: "tag_ids[0]", Message: "is not in the catalog"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.call()
+
+			var bannerErr *Error
+			var fields []models.FieldError
+			if errs.As(err, &bannerErr) {
+				fields = bannerErr.Fields
+			}
+			if (err != nil) != (tt.wantFields != nil) || !reflect.DeepEqual(fields, tt.wantFields) {
+				t.Errorf("error = %v, fields = %+v, want %+v", err, fields, tt.wantFields)
+			}
+		})
+	}
+}
+
+func TestBanner_EventStats(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	from := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2024, 4, 2, 0, 0, 0, 0, time.UTC)
+
+	mockRepo := mock_repository.NewMockRepository(ctrl)
+	mockRepo.EXPECT().GetEventStats(3, from, to).Return([]models.DailyStats{
+		{Day: "2024-04-01", Version: 1, Impressions: 200, Clicks: 10},
+		{Day: "2024-04-02", Version: 2, Variant: "b", Impressions: 0, Clicks: 1},
+		{Day: "2024-04-02", Version: 2, Variant: "a", Impressions: 100, Clicks: 20},
+	}, nil)
+	mockRepo.EXPECT().GetEventStats(4, from, to).Return(nil, errs.WithMessage(sql.ErrNoRows, "banner 4 not found"))
+
+	b := &Banner{Ctx: context.Background(), Repo: mockRepo}
+
+	got, err := b.EventStats(3, from, to)
+	if err != nil {
+		t.Fatalf("EventStats() error = %v", err)
+	}
+	want := &models.BannerStats{
+		BannerID:    3,
+		From:        "2024-04-01",
+		To:          "2024-04-02",
+		Impressions: 300,
+		Clicks:      31,
+		CTR:         31.0 / 300,
+		Days: []models.DailyStats{
+			{Day: "2024-04-01", Version: 1, Impressions: 200, Clicks: 10, CTR: 0.05},
+			{Day: "2024-04-02", Version: 2, Variant: "b", Impressions: 0, Clicks: 1, CTR: 0},
+			{Day: "2024-04-02", Version: 2, Variant: "a", Impressions: 100, Clicks: 20, CTR: 0.2},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("EventStats() got = %+v, want %+v", got, want)
+	}
+
+	if _, err = b.EventStats(4, from, to); !errs.Is(err, sql.ErrNoRows) {
+		t.Errorf("EventStats() of a missing banner error = %v, want sql.ErrNoRows", err)
+	}
+}
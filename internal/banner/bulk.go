@@ -0,0 +1,66 @@
+package banner
+
+import (
+	"context"
+
+	"github.com/mashmorsik/banners-service/infrastructure/data/cache"
+	"github.com/mashmorsik/banners-service/pkg/models"
+	errs "github.com/pkg/errors"
+)
+
+const (
+	bulkDeleteJobType          = "bulk_delete"
+	defaultBulkDeleteBatchSize = 100
+)
+
+// BulkDelete enqueues a job moving every banner of the feature and/or tag to the trash.
+func (b *Banner) BulkDelete(featureID, tagID int) (*models.Job, error) {
+	if featureID == 0 && tagID == 0 {
+		return nil, validationErrorf("feature_id or tag_id is required for bulk delete")
+	}
+
+	batchSize := b.Config.Jobs.BulkDeleteBatchSize
+	if batchSize <= 0 {
+		batchSize = defaultBulkDeleteBatchSize
+	}
+
+	j, err := b.Jobs.Start(bulkDeleteJobType, func(ctx context.Context, report func(affected int)) (int, error) {
+		var affected int
+		for {
+			if err := ctx.Err(); err != nil {
+				return affected, err
+			}
+
+			deleted, pairs, err := b.Repo.DeleteBatch(featureID, tagID, batchSize)
+			if err != nil {
+				return affected, errs.WithMessagef(err, "fail to delete banners with feature: %d and tag: %d",
+					featureID, tagID)
+			}
+
+			for _, pair := range pairs {
+				b.Cache.Delete(cache.NewKey(pair.FeatureID, pair.TagID))
+			}
+
+			affected += deleted
+			report(affected)
+
+			if deleted < batchSize {
+				return affected, nil
+			}
+		}
+	})
+	if err != nil {
+		return nil, errs.WithMessagef(err, "fail to start bulk delete of feature: %d and tag: %d", featureID, tagID)
+	}
+
+	return j, nil
+}
+
+func (b *Banner) GetJob(jobID string) (*models.Job, error) {
+	j, err := b.Jobs.Get(jobID)
+	if err != nil {
+		return nil, errs.WithMessagef(err, "fail to get job: %s", jobID)
+	}
+
+	return j, nil
+}
@@ -0,0 +1,172 @@
+package banner
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/mashmorsik/banners-service/pkg/models"
+	errs "github.com/pkg/errors"
+)
+
+// ListCatalog returns the tags or features ordered by ID.
+func (b *Banner) ListCatalog(kind models.CatalogKind, includeArchived bool) ([]*models.CatalogEntry, error) {
+	entries, err := b.Repo.ListCatalog(kind, nil, includeArchived)
+	if err != nil {
+		return nil, errs.WithMessagef(err, "fail to list %s catalog", kind)
+	}
+
+	return entries, nil
+}
+
+func (b *Banner) GetCatalogEntry(kind models.CatalogKind, id int) (*models.CatalogEntry, error) {
+	entry, err := b.Repo.GetCatalogEntry(kind, id)
+	if err != nil {
+		return nil, errs.WithMessagef(err, "%s not found with id: %d", kind, id)
+	}
+
+	return entry, nil
+}
+
+func (b *Banner) CreateCatalogEntry(kind models.CatalogKind, entry *models.CatalogEntry) error {
+	if err := b.Repo.CreateCatalogEntry(kind, entry); err != nil {
+		return errs.WithMessagef(err, "fail to create %s with id: %d", kind, entry.ID)
+	}
+
+	return nil
+}
+
+func (b *Banner) UpdateCatalogEntry(kind models.CatalogKind, id int, patch models.CatalogPatch) (*models.CatalogEntry, error) {
+	entry, err := b.Repo.UpdateCatalogEntry(kind, id, patch)
+	if err != nil {
+		return nil, errs.WithMessagef(err, "fail to update %s with id: %d", kind, id)
+	}
+
+	return entry, nil
+}
+
+// DeleteCatalogEntry removes an entry no banner refers to, entries in use can be archived instead.
+func (b *Banner) DeleteCatalogEntry(kind models.CatalogKind, id int) error {
+	if err := b.Repo.DeleteCatalogEntry(kind, id); err != nil {
+		return errs.WithMessagef(err, "fail to delete %s with id: %d", kind, id)
+	}
+
+	return nil
+}
+
+// checkCatalog rejects features and tags of the request that are missing in the catalog or
+// archived, in strict mode only. Zero fields of an update keep their values and are not checked.
+func (b *Banner) checkCatalog(req *models.Banner) error {
+	if !b.Config.Catalog.Strict {
+		return nil
+	}
+
+	var fields []models.FieldError
+	if req.FeatureID != 0 {
+		missing, archived, err := b.lookupCatalog(models.CatalogFeatures, []int{req.FeatureID})
+		if err != nil {
+			return err
+		}
+		fields = append(fields, catalogFieldErrors("feature_id", []int{req.FeatureID}, missing, archived, false)...)
+	}
+	if len(req.TagIDs) > 0 {
+		missing, archived, err := b.lookupCatalog(models.CatalogTags, req.TagIDs)
+		if err != nil {
+			return err
+		}
+		fields = append(fields, catalogFieldErrors("tag_ids", req.TagIDs, missing, archived, true)...)
+	}
+
+	if len(fields) > 0 {
+		return fieldsErrorf(fields, "unknown or archived feature: %d or tags: %v", req.FeatureID, req.TagIDs)
+	}
+
+	return nil
+}
+
+func (b *Banner) lookupCatalog(kind models.CatalogKind, ids []int) (missing, archived []int, err error) {
+	entries, err := b.Repo.ListCatalog(kind, ids, true)
+	if err != nil {
+		return nil, nil, errs.WithMessagef(err, "fail to look up %s catalog", kind)
+	}
+
+	found := make(map[int]bool, len(entries))
+	for _, entry := range entries {
+		found[entry.ID] = true
+		if entry.Archived {
+			archived = append(archived, entry.ID)
+		}
+	}
+	for _, id := range ids {
+		if !found[id] {
+			missing = append(missing, id)
+		}
+	}
+
+	return missing, archived, nil
+}
+
+func catalogFieldErrors(field string, ids, missing, archived []int, indexed bool) []models.FieldError {
+	var fields []models.FieldError
+	for i, id := range ids {
+		name := field
+		if indexed {
+			name = fmt.Sprintf("%s[%d]", field, i)
+		}
+
+		switch {
+		case slices.Contains(missing, id):
+			fields = append(fields, models.FieldError{Field: name, Message: "is not in the catalog"})
+		case slices.Contains(archived, id):
+			fields = append(fields, models.FieldError{Field: name, Message: "is archived"})
+		}
+	}
+
+	return fields
+}
+
+// attachNames sets the catalog names of the features and tags of the banners.
+func (b *Banner) attachNames(banners []*models.Banner) error {
+	if len(banners) == 0 {
+		return nil
+	}
+
+	var featureIDs, tagIDs []int
+	for _, banner := range banners {
+		featureIDs = append(featureIDs, banner.FeatureID)
+		tagIDs = append(tagIDs, banner.TagIDs...)
+	}
+
+	featureNames, err := b.catalogNames(models.CatalogFeatures, featureIDs)
+	if err != nil {
+		return err
+	}
+	tagNames, err := b.catalogNames(models.CatalogTags, tagIDs)
+	if err != nil {
+		return err
+	}
+
+	for _, banner := range banners {
+		banner.FeatureName = featureNames[banner.FeatureID]
+		banner.TagNames = make([]string, len(banner.TagIDs))
+		for i, tagID := range banner.TagIDs {
+			banner.TagNames[i] = tagNames[tagID]
+		}
+	}
+
+	return nil
+}
+
+func (b *Banner) catalogNames(kind models.CatalogKind, ids []int) (map[int]string, error) {
+	slices.Sort(ids)
+	entries, err := b.Repo.ListCatalog(kind, slices.Compact(ids), true)
+	if err != nil {
+		return nil, errs.WithMessagef(err, "fail to get %s names", kind)
+	}
+
+	names := make(map[int]string, len(entries))
+	for _, entry := range entries {
+		names[entry.ID] = entry.Name
+	}
+
+	return names, nil
+}
@@ -0,0 +1,188 @@
+package banner
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/mashmorsik/banners-service/pkg/models"
+)
+
+// diffVersions compares two versions of the same banner. The patch transforms
+// the from version into the to version, tag removals are emitted in
+// descending index order so the operations stay valid when applied in turn.
+func diffVersions(from, to *models.Banner) *models.BannerDiff {
+	diff := &models.BannerDiff{
+		BannerID:      from.ID,
+		From:          from.Version,
+		To:            to.Version,
+		AddedTagIDs:   []int{},
+		RemovedTagIDs: []int{},
+		Patch:         []models.PatchOp{},
+		Summary:       []string{},
+	}
+
+	diffObjects(diff, "/content", "", from.Content, to.Content)
+	diffLocales(diff, from.Locales, to.Locales)
+	diffVariants(diff, from.Variants, to.Variants)
+
+	if from.FeatureID != to.FeatureID {
+		diff.Patch = append(diff.Patch, models.PatchOp{Op: "replace", Path: "/feature_id", Value: to.FeatureID})
+		diff.Summary = append(diff.Summary, fmt.Sprintf("feature_id changed from %d to %d", from.FeatureID, to.FeatureID))
+	}
+
+	for i := len(from.TagIDs) - 1; i >= 0; i-- {
+		if !slices.Contains(to.TagIDs, from.TagIDs[i]) {
+			diff.RemovedTagIDs = append(diff.RemovedTagIDs, from.TagIDs[i])
+			diff.Patch = append(diff.Patch, models.PatchOp{Op: "remove", Path: fmt.Sprintf("/tag_ids/%d", i)})
+		}
+	}
+	slices.Sort(diff.RemovedTagIDs)
+
+	for _, tagID := range to.TagIDs {
+		if !slices.Contains(from.TagIDs, tagID) {
+			diff.AddedTagIDs = append(diff.AddedTagIDs, tagID)
+			diff.Patch = append(diff.Patch, models.PatchOp{Op: "add", Path: "/tag_ids/-", Value: tagID})
+		}
+	}
+
+	if len(diff.AddedTagIDs) > 0 {
+		diff.Summary = append(diff.Summary, fmt.Sprintf("tag_ids added: %v", diff.AddedTagIDs))
+	}
+	if len(diff.RemovedTagIDs) > 0 {
+		diff.Summary = append(diff.Summary, fmt.Sprintf("tag_ids removed: %v", diff.RemovedTagIDs))
+	}
+
+	return diff
+}
+
+// diffObjects compares the content field by field in key order, nested objects are
+// compared recursively while any other changed value, arrays included, is replaced whole.
+// Summary lines name a field by its dotted path relative to the content.
+func diffObjects(diff *models.BannerDiff, path, field string, from, to map[string]any) {
+	keys := make([]string, 0, len(from)+len(to))
+	for key := range from {
+		keys = append(keys, key)
+	}
+	for key := range to {
+		if _, ok := from[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		keyPath := path + "/" + escapePointer(key)
+		keyField := key
+		if field != "" {
+			keyField = field + "." + key
+		}
+
+		fromValue, inFrom := from[key]
+		toValue, inTo := to[key]
+		switch {
+		case !inTo:
+			diff.Patch = append(diff.Patch, models.PatchOp{Op: "remove", Path: keyPath})
+			diff.Summary = append(diff.Summary, fmt.Sprintf("%s removed", keyField))
+		case !inFrom:
+			diff.Patch = append(diff.Patch, models.PatchOp{Op: "add", Path: keyPath, Value: toValue})
+			diff.Summary = append(diff.Summary, fmt.Sprintf("%s added: %s", keyField, jsonValue(toValue)))
+		default:
+			fromObject, fromIsObject := fromValue.(map[string]any)
+			toObject, toIsObject := toValue.(map[string]any)
+			if fromIsObject && toIsObject {
+				diffObjects(diff, keyPath, keyField, fromObject, toObject)
+				continue
+			}
+			if reflect.DeepEqual(fromValue, toValue) {
+				continue
+			}
+			diff.Patch = append(diff.Patch, models.PatchOp{Op: "replace", Path: keyPath, Value: toValue})
+			diff.Summary = append(diff.Summary, fmt.Sprintf("%s changed from %s to %s",
+				keyField, jsonValue(fromValue), jsonValue(toValue)))
+		}
+	}
+}
+
+// diffVariants replaces the variants as a whole, like an update does, the summary lists
+// the variant keys with their weights.
+func diffVariants(diff *models.BannerDiff, from, to []models.Variant) {
+	switch {
+	case len(from) == 0 && len(to) == 0:
+	case len(from) == 0:
+		diff.Patch = append(diff.Patch, models.PatchOp{Op: "add", Path: "/variants", Value: to})
+		diff.Summary = append(diff.Summary, fmt.Sprintf("variants added: %s", variantWeights(to)))
+	case len(to) == 0:
+		diff.Patch = append(diff.Patch, models.PatchOp{Op: "remove", Path: "/variants"})
+		diff.Summary = append(diff.Summary, "variants removed")
+	case !reflect.DeepEqual(from, to):
+		diff.Patch = append(diff.Patch, models.PatchOp{Op: "replace", Path: "/variants", Value: to})
+		diff.Summary = append(diff.Summary, fmt.Sprintf("variants changed to %s", variantWeights(to)))
+	}
+}
+
+func variantWeights(variants []models.Variant) string {
+	weights := make([]string, len(variants))
+	for i, variant := range variants {
+		weights[i] = fmt.Sprintf("%s:%d", variant.Key, variant.Weight)
+	}
+
+	return "[" + strings.Join(weights, " ") + "]"
+}
+
+// diffLocales compares the locales one by one. A version without locales has no locales
+// object, so the first locale adds the object as a whole and removing the last one removes
+// it, otherwise the patch would point into a missing object.
+func diffLocales(diff *models.BannerDiff, from, to map[string]models.Content) {
+	switch {
+	case len(from) == 0 && len(to) == 0:
+	case len(from) == 0:
+		diff.Patch = append(diff.Patch, models.PatchOp{Op: "add", Path: "/locales", Value: to})
+		for _, locale := range sortedLocales(to) {
+			diff.Summary = append(diff.Summary, fmt.Sprintf("locales.%s added: %s", locale, jsonValue(to[locale])))
+		}
+	case len(to) == 0:
+		diff.Patch = append(diff.Patch, models.PatchOp{Op: "remove", Path: "/locales"})
+		for _, locale := range sortedLocales(from) {
+			diff.Summary = append(diff.Summary, fmt.Sprintf("locales.%s removed", locale))
+		}
+	default:
+		diffObjects(diff, "/locales", "locales", localeObjects(from), localeObjects(to))
+	}
+}
+
+func sortedLocales(locales map[string]models.Content) []string {
+	keys := make([]string, 0, len(locales))
+	for locale := range locales {
+		keys = append(keys, locale)
+	}
+	slices.Sort(keys)
+
+	return keys
+}
+
+// localeObjects lets diffObjects compare the locales as nested objects.
+func localeObjects(locales map[string]models.Content) map[string]any {
+	objects := make(map[string]any, len(locales))
+	for locale, content := range locales {
+		objects[locale] = map[string]any(content)
+	}
+
+	return objects
+}
+
+// escapePointer escapes a key for a JSON pointer (RFC 6901).
+func escapePointer(key string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(key, "~", "~0"), "/", "~1")
+}
+
+func jsonValue(value any) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+
+	return string(data)
+}
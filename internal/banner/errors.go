@@ -0,0 +1,68 @@
+package banner
+
+import (
+	"database/sql"
+
+	"github.com/mashmorsik/banners-service/pkg/models"
+	"github.com/mashmorsik/banners-service/repository"
+	errs "github.com/pkg/errors"
+)
+
+// ErrorKind tells the caller what went wrong without looking into the error message.
+type ErrorKind int
+
+const (
+	KindInternal ErrorKind = iota
+	KindNotFound
+	KindConflict
+	KindValidation
+	KindForbidden
+)
+
+// Error carries the kind of a failure, its message is meant to be shown to the client.
+// Fields lists the rejected fields of a validation error when they are known.
+type Error struct {
+	Kind   ErrorKind
+	Err    error
+	Fields []models.FieldError
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// KindOf classifies an error returned by Banner. Missing rows reported by the repository are
+// not found errors, version mismatches, duplicates, overlaps and entries in use are conflicts, schedules
+// rejected by the database are validation errors, anything unknown is internal.
+func KindOf(err error) ErrorKind {
+	var bannerErr *Error
+	switch {
+	case errs.As(err, &bannerErr):
+		return bannerErr.Kind
+	case errs.Is(err, sql.ErrNoRows):
+		return KindNotFound
+	case errs.Is(err, repository.ErrVersionMismatch), errs.Is(err, repository.ErrAlreadyExists),
+		errs.Is(err, repository.ErrInUse), errs.Is(err, repository.ErrOverlap):
+		return KindConflict
+	case errs.Is(err, repository.ErrInvalidSchedule):
+		return KindValidation
+	default:
+		return KindInternal
+	}
+}
+
+func validationErrorf(format string, args ...any) error {
+	return &Error{Kind: KindValidation, Err: errs.Errorf(format, args...)}
+}
+
+func fieldsErrorf(fields []models.FieldError, format string, args ...any) error {
+	return &Error{Kind: KindValidation, Err: errs.Errorf(format, args...), Fields: fields}
+}
+
+func conflictErrorf(format string, args ...any) error {
+	return &Error{Kind: KindConflict, Err: errs.Errorf(format, args...)}
+}
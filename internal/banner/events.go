@@ -0,0 +1,39 @@
+package banner
+
+import (
+	"time"
+
+	"github.com/mashmorsik/banners-service/pkg/models"
+	errs "github.com/pkg/errors"
+)
+
+// RecordEvents counts the events in memory until the next flush and returns how many of
+// them were accepted.
+func (b *Banner) RecordEvents(events []models.Event) int {
+	return b.Events.Add(events)
+}
+
+// EventStats returns the daily impressions and clicks of the banner between the days, both
+// included. Events still buffered in memory are not counted yet.
+func (b *Banner) EventStats(bannerID int, from, to time.Time) (*models.BannerStats, error) {
+	days, err := b.Repo.GetEventStats(bannerID, from, to)
+	if err != nil {
+		return nil, errs.WithMessagef(err, "fail to get event stats for bannerID: %d", bannerID)
+	}
+
+	stats := &models.BannerStats{
+		BannerID: bannerID,
+		From:     from.Format(time.DateOnly),
+		To:       to.Format(time.DateOnly),
+		Days:     days,
+	}
+	for i := range stats.Days {
+		day := &stats.Days[i]
+		day.CTR = models.CTR(day.Impressions, day.Clicks)
+		stats.Impressions += day.Impressions
+		stats.Clicks += day.Clicks
+	}
+	stats.CTR = models.CTR(stats.Impressions, stats.Clicks)
+
+	return stats, nil
+}
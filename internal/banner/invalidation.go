@@ -0,0 +1,21 @@
+package banner
+
+import (
+	"github.com/mashmorsik/banners-service/infrastructure/data/cache"
+	"github.com/mashmorsik/logger"
+)
+
+// evictBanner drops every cached feature/tag key the banner was ever stored with, so old
+// and new tag sets are both covered. The whole cache is flushed when the keys are unknown.
+func (b *Banner) evictBanner(bannerID int) {
+	pairs, err := b.Repo.GetFeatureTags(bannerID)
+	if err != nil {
+		logger.Errf("fail to get feature tags of banner %d, flushing cache: %s", bannerID, err)
+		b.Cache.Flush()
+		return
+	}
+
+	for _, pair := range pairs {
+		b.Cache.Delete(cache.NewKey(pair.FeatureID, pair.TagID))
+	}
+}
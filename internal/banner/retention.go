@@ -0,0 +1,64 @@
+package banner
+
+import (
+	"time"
+
+	"github.com/mashmorsik/logger"
+	errs "github.com/pkg/errors"
+)
+
+// Prune removes versions of the banner that fall outside the configured retention policy,
+// an unknown or deleted banner is not found.
+func (b *Banner) Prune(bannerID int) (int, error) {
+	if _, err := b.Repo.GetVersion(bannerID, 0); err != nil {
+		return 0, errs.WithMessagef(err, "banner not found with bannerID: %d", bannerID)
+	}
+
+	pruned, err := b.pruneVersions(bannerID)
+	if err != nil {
+		return 0, errs.WithMessagef(err, "fail to prune versions for bannerID: %d", bannerID)
+	}
+
+	return pruned, nil
+}
+
+// RetentionWorker periodically prunes versions of every banner until the context is done.
+func (b *Banner) RetentionWorker() {
+	if b.Config.Retention.WorkerDuration <= 0 {
+		logger.Infof("retention worker is disabled")
+		return
+	}
+
+	ticker := time.NewTicker(b.Config.Retention.WorkerDuration)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.Ctx.Done():
+			return
+		case <-ticker.C:
+			pruned, err := b.pruneVersions(0)
+			if err != nil {
+				logger.Errf("fail to prune banner versions: %s", err)
+				continue
+			}
+			if pruned > 0 {
+				logger.Infof("retention worker pruned %d banner versions", pruned)
+			}
+		}
+	}
+}
+
+func (b *Banner) pruneVersions(bannerID int) (int, error) {
+	policy := b.Config.Retention
+	if policy.KeepLastVersions <= 0 && policy.MaxVersionAge <= 0 {
+		return 0, nil
+	}
+
+	var olderThan time.Time
+	if policy.MaxVersionAge > 0 {
+		olderThan = time.Now().Add(-policy.MaxVersionAge)
+	}
+
+	return b.Repo.PruneVersions(bannerID, max(policy.KeepLastVersions, 0), olderThan)
+}
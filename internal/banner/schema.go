@@ -0,0 +1,188 @@
+package banner
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/go-openapi/spec"
+	"github.com/go-openapi/strfmt"
+	"github.com/go-openapi/validate"
+	"github.com/mashmorsik/banners-service/pkg/models"
+	errs "github.com/pkg/errors"
+)
+
+// draft04 is the meta-schema every content schema must match.
+var draft04 = spec.MustLoadJSONSchemaDraft04()
+
+// PutSchema registers the schema as the next version of the feature content schema,
+// banners created or updated afterwards must match it.
+func (b *Banner) PutSchema(featureID int, schema json.RawMessage) (*models.FeatureSchema, error) {
+	if err := checkSchema(schema); err != nil {
+		return nil, err
+	}
+
+	result, err := b.Repo.PutFeatureSchema(featureID, schema)
+	if err != nil {
+		return nil, errs.WithMessagef(err, "fail to put schema for feature: %d", featureID)
+	}
+
+	return result, nil
+}
+
+// GetSchema returns the given version of the feature content schema, version 0 is the last one.
+func (b *Banner) GetSchema(featureID, version int) (*models.FeatureSchema, error) {
+	result, err := b.Repo.GetFeatureSchema(featureID, version)
+	if err != nil {
+		return nil, errs.WithMessagef(err, "schema not found for feature: %d", featureID)
+	}
+
+	return result, nil
+}
+
+// validateContents checks every content by its field against the last schema of the
+// feature, features without a schema accept any content.
+func (b *Banner) validateContents(featureID int, contents map[string]models.Content) error {
+	schema, err := b.Repo.GetFeatureSchema(featureID, 0)
+	if errs.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return errs.WithMessagef(err, "fail to get schema for feature: %d", featureID)
+	}
+
+	var compiled spec.Schema
+	if err = json.Unmarshal(schema.Schema, &compiled); err != nil {
+		return errs.WithMessagef(err, "fail to read schema version %d of feature: %d", schema.Version, featureID)
+	}
+
+	var fields []models.FieldError
+	for field, content := range contents {
+		if content == nil {
+			content = models.Content{}
+		}
+		fields = append(fields, schemaErrors(&compiled, field, map[string]any(content))...)
+	}
+	if len(fields) > 0 {
+		slices.SortStableFunc(fields, func(a, b models.FieldError) int {
+			return strings.Compare(a.Field, b.Field)
+		})
+		return fieldsErrorf(fields, "content does not match schema version %d of feature: %d",
+			schema.Version, featureID)
+	}
+
+	return nil
+}
+
+// validateMerged checks the banner an update results in once merged with the last version:
+// its schedule and every content, the kept locales and variants too, against the schema of
+// its feature.
+func (b *Banner) validateMerged(merged *models.Banner) error {
+	if err := validateSchedule(merged); err != nil {
+		return err
+	}
+
+	return b.validateContents(merged.FeatureID, bannerContents(merged))
+}
+
+// bannerContents returns every content of a banner by its request field.
+func bannerContents(req *models.Banner) map[string]models.Content {
+	contents := map[string]models.Content{"content": req.Content}
+	addLocaleContents(contents, "locales", req.Locales)
+	addVariantContents(contents, req.Variants)
+
+	return contents
+}
+
+func addVariantContents(contents map[string]models.Content, variants []models.Variant) {
+	for i, variant := range variants {
+		field := fmt.Sprintf("variants[%d]", i)
+		contents[field+".content"] = variant.Content
+		addLocaleContents(contents, field+".locales", variant.Locales)
+	}
+}
+
+func addLocaleContents(contents map[string]models.Content, field string, locales map[string]models.Content) {
+	for locale, content := range locales {
+		contents[field+"."+locale] = content
+	}
+}
+
+// checkSchema accepts a JSON Schema draft 4 object. References must point into the schema
+// itself, so checking content never fetches documents from elsewhere.
+func checkSchema(raw json.RawMessage) error {
+	var doc any
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return fieldsErrorf([]models.FieldError{{Field: "schema", Message: "must be a valid JSON object"}},
+			"invalid content schema")
+	}
+	if _, ok := doc.(map[string]any); !ok {
+		return fieldsErrorf([]models.FieldError{{Field: "schema", Message: "must be a JSON object"}},
+			"invalid content schema")
+	}
+
+	if fields := schemaErrors(draft04, "schema", doc); len(fields) > 0 {
+		return fieldsErrorf(fields, "invalid content schema")
+	}
+	if field, ref, ok := externalRef("schema", doc); ok {
+		return fieldsErrorf([]models.FieldError{{Field: field, Message: fmt.Sprintf("must point into the schema, got %q", ref)}},
+			"invalid content schema")
+	}
+
+	return nil
+}
+
+// externalRef finds the first $ref that does not start with "#".
+func externalRef(path string, node any) (field, ref string, ok bool) {
+	switch value := node.(type) {
+	case map[string]any:
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+
+		for _, key := range keys {
+			if ref, isString := value[key].(string); key == "$ref" && isString && !strings.HasPrefix(ref, "#") {
+				return path + ".$ref", ref, true
+			}
+			if field, ref, ok = externalRef(path+"."+key, value[key]); ok {
+				return field, ref, true
+			}
+		}
+	case []any:
+		for i, item := range value {
+			if field, ref, ok = externalRef(fmt.Sprintf("%s.%d", path, i), item); ok {
+				return field, ref, true
+			}
+		}
+	}
+
+	return "", "", false
+}
+
+// schemaErrors validates the data and reports the errors by field, sorted by field.
+// Array indexes are not part of the field of an error in an array item.
+func schemaErrors(schema *spec.Schema, root string, data any) []models.FieldError {
+	result := validate.NewSchemaValidator(schema, nil, root, strfmt.Default).Validate(data)
+
+	fields := make([]models.FieldError, 0, len(result.Errors))
+	for _, err := range result.Errors {
+		field, message, ok := strings.Cut(err.Error(), " in body ")
+		if !ok {
+			field, message = root, err.Error()
+		}
+		fields = append(fields, models.FieldError{Field: field, Message: message})
+	}
+
+	slices.SortFunc(fields, func(a, b models.FieldError) int {
+		if c := strings.Compare(a.Field, b.Field); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Message, b.Message)
+	})
+
+	return slices.Compact(fields)
+}
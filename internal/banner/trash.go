@@ -0,0 +1,59 @@
+package banner
+
+import (
+	"time"
+
+	"github.com/mashmorsik/banners-service/pkg/models"
+	"github.com/mashmorsik/logger"
+	errs "github.com/pkg/errors"
+)
+
+func (b *Banner) GetTrash() ([]*models.Banner, error) {
+	banners, err := b.Repo.GetTrash(0)
+	if err != nil {
+		return nil, errs.WithMessage(err, "fail to get deleted banners")
+	}
+	if err = b.attachNames(banners); err != nil {
+		return nil, err
+	}
+
+	return banners, nil
+}
+
+// Restore brings a soft deleted banner back. An active banner is only restored if no
+// other active banner took its feature and tags in an overlapping window meanwhile.
+func (b *Banner) Restore(bannerID int) error {
+	if err := b.Repo.Restore(bannerID); err != nil {
+		return errs.WithMessagef(err, "fail to restore banner with bannerID: %d", bannerID)
+	}
+	b.evictBanner(bannerID)
+
+	return nil
+}
+
+// PurgeWorker periodically removes banners that stayed in the trash longer than the grace period.
+func (b *Banner) PurgeWorker() {
+	if b.Config.Trash.PurgeWorkerDuration <= 0 || b.Config.Trash.GracePeriod <= 0 {
+		logger.Infof("trash purge worker is disabled")
+		return
+	}
+
+	ticker := time.NewTicker(b.Config.Trash.PurgeWorkerDuration)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.Ctx.Done():
+			return
+		case <-ticker.C:
+			purged, err := b.Repo.PurgeDeleted(time.Now().Add(-b.Config.Trash.GracePeriod))
+			if err != nil {
+				logger.Errf("fail to purge deleted banners: %s", err)
+				continue
+			}
+			if purged > 0 {
+				logger.Infof("trash purge worker removed %d banners", purged)
+			}
+		}
+	}
+}
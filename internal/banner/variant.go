@@ -0,0 +1,52 @@
+package banner
+
+import (
+	"fmt"
+	"hash/fnv"
+
+	"github.com/mashmorsik/banners-service/infrastructure/data/cache"
+	"github.com/mashmorsik/banners-service/pkg/models"
+)
+
+// userBanner returns the content of the variant assigned to the user, the default content
+// with an empty variant when the user gets no variant.
+func userBanner(entry *cache.Entry, key cache.Key, userID string) *models.UserBanner {
+	served := &models.UserBanner{BannerID: entry.BannerID, Version: entry.Version, Content: entry.Content}
+	if variant := assignVariant(entry.Variants, key.FeatureID, key.TagID, userID); variant != nil {
+		served.Variant = variant.Key
+		served.Content = variant.Content
+	}
+
+	return served
+}
+
+// assignVariant hashes the user id together with the feature and tag pair into a bucket of
+// the total weight, so a user keeps the variant while the weights stay the same and the
+// buckets of different pairs are independent. Variants with zero weight get no users, users
+// without an id get no variant.
+func assignVariant(variants []models.Variant, featureID, tagID int, userID string) *models.Variant {
+	if userID == "" {
+		return nil
+	}
+
+	var total uint64
+	for _, variant := range variants {
+		total += uint64(max(variant.Weight, 0))
+	}
+	if total == 0 {
+		return nil
+	}
+
+	h := fnv.New64a()
+	_, _ = fmt.Fprintf(h, "%d:%d:%s", featureID, tagID, userID)
+	bucket := h.Sum64() % total
+	for i := range variants {
+		weight := uint64(max(variants[i].Weight, 0))
+		if bucket < weight {
+			return &variants[i]
+		}
+		bucket -= weight
+	}
+
+	return nil
+}
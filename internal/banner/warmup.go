@@ -0,0 +1,30 @@
+package banner
+
+import (
+	"time"
+
+	"github.com/mashmorsik/banners-service/infrastructure/data/cache"
+	errs "github.com/pkg/errors"
+)
+
+const defaultWarmUpTimeout = 30 * time.Second
+
+// WarmUp loads every active banner into the cache with all of its locales, so a fresh
+// instance doesn't send all of its first user requests to the database.
+func (b *Banner) WarmUp() (int, error) {
+	timeout := b.Config.Cache.WarmUp.Timeout
+	if timeout <= 0 {
+		timeout = defaultWarmUpTimeout
+	}
+
+	banners, err := b.Repo.GetActiveContents(timeout)
+	if err != nil {
+		return 0, errs.WithMessage(err, "fail to get active banners for cache warm-up")
+	}
+
+	for _, banner := range banners {
+		b.cacheContent(cache.NewKey(banner.FeatureID, banner.TagIDs[0]), cacheEntry(banner), banner.EndsAt)
+	}
+
+	return len(banners), nil
+}
@@ -0,0 +1,215 @@
+package event
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mashmorsik/banners-service/pkg/models"
+	"github.com/mashmorsik/logger"
+)
+
+const (
+	defaultFlushInterval = 10 * time.Second
+	defaultFlushTimeout  = 10 * time.Second
+	defaultMaxPending    = 100000
+)
+
+// Store adds the counts to the stored totals, all of them or none: the counts of a failed
+// call are restored and written again by the next flush.
+type Store func(ctx context.Context, counts []models.EventCount) error
+
+// Stats is a snapshot of the buffer counters. Dropped events didn't fit into the buffer,
+// failed flushes keep their counts for the next one.
+type Stats struct {
+	Accepted      uint64 `json:"accepted"`
+	Dropped       uint64 `json:"dropped"`
+	Flushed       uint64 `json:"flushed"`
+	FailedFlushes uint64 `json:"failed_flushes"`
+	Pending       int    `json:"pending"`
+}
+
+type counter struct {
+	bannerID int
+	version  int
+	variant  string
+	day      time.Time
+}
+
+// Buffer counts events in memory by banner version, variant and UTC day, so recording an
+// event takes a map update under a mutex and never waits for the database. Run writes the
+// counts to the store in batches.
+type Buffer struct {
+	Ctx           context.Context
+	store         Store
+	flushInterval time.Duration
+	flushTimeout  time.Duration
+	maxPending    int
+
+	mu      sync.Mutex
+	pending map[counter]*models.EventCount
+	// flushMu serializes flushes, so the counts of a failed flush are merged back before
+	// the next one starts.
+	flushMu sync.Mutex
+	full    chan struct{}
+
+	accepted      atomic.Uint64
+	dropped       atomic.Uint64
+	flushed       atomic.Uint64
+	failedFlushes atomic.Uint64
+}
+
+func NewBuffer(ctx context.Context, store Store, flushInterval, flushTimeout time.Duration, maxPending int) *Buffer {
+	if flushInterval <= 0 {
+		flushInterval = defaultFlushInterval
+	}
+	if flushTimeout <= 0 {
+		flushTimeout = defaultFlushTimeout
+	}
+	if maxPending <= 0 {
+		maxPending = defaultMaxPending
+	}
+
+	return &Buffer{
+		Ctx:           ctx,
+		store:         store,
+		flushInterval: flushInterval,
+		flushTimeout:  flushTimeout,
+		maxPending:    maxPending,
+		pending:       make(map[counter]*models.EventCount),
+		full:          make(chan struct{}, 1),
+	}
+}
+
+// Add counts the events and returns how many of them were accepted. Events that need a
+// new counter while the buffer is full are dropped, the next flush makes room.
+func (b *Buffer) Add(events []models.Event) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var accepted int
+	for _, event := range events {
+		key := counter{
+			bannerID: event.BannerID,
+			version:  event.Version,
+			variant:  event.Variant,
+			day:      event.Timestamp.UTC().Truncate(24 * time.Hour),
+		}
+		count, ok := b.pending[key]
+		if !ok {
+			if len(b.pending) >= b.maxPending {
+				continue
+			}
+			count = &models.EventCount{BannerID: key.bannerID, Version: key.version, Variant: key.variant, Day: key.day}
+			b.pending[key] = count
+		}
+
+		switch event.Type {
+		case models.EventImpression:
+			count.Impressions++
+		case models.EventClick:
+			count.Clicks++
+		}
+		accepted++
+	}
+
+	b.accepted.Add(uint64(accepted))
+	b.dropped.Add(uint64(len(events) - accepted))
+	if len(b.pending) >= b.maxPending/2 {
+		select {
+		case b.full <- struct{}{}:
+		default:
+		}
+	}
+
+	return accepted
+}
+
+// Run flushes the buffer every flush interval, and sooner when it is half full, until the
+// context is done. The caller flushes the rest on shutdown.
+func (b *Buffer) Run() {
+	ticker := time.NewTicker(b.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.Ctx.Done():
+			return
+		case <-ticker.C:
+		case <-b.full:
+		}
+
+		if err := b.Flush(); err != nil {
+			logger.Errf("fail to flush banner events: %s", err)
+		}
+	}
+}
+
+// Flush writes the pending counts to the store. The counts of a failed write are kept for
+// the next flush as long as they fit into the buffer.
+func (b *Buffer) Flush() error {
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+
+	b.mu.Lock()
+	pending := b.pending
+	b.pending = make(map[counter]*models.EventCount, len(pending))
+	b.mu.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	counts := make([]models.EventCount, 0, len(pending))
+	var events uint64
+	for _, count := range pending {
+		counts = append(counts, *count)
+		events += uint64(count.Impressions + count.Clicks)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), b.flushTimeout)
+	defer cancel()
+
+	if err := b.store(ctx, counts); err != nil {
+		b.failedFlushes.Add(1)
+		b.restore(pending)
+		return err
+	}
+	b.flushed.Add(events)
+
+	return nil
+}
+
+// restore merges the counts of a failed flush into the events counted since.
+func (b *Buffer) restore(pending map[counter]*models.EventCount) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for key, count := range pending {
+		if current, ok := b.pending[key]; ok {
+			current.Impressions += count.Impressions
+			current.Clicks += count.Clicks
+			continue
+		}
+		if len(b.pending) >= b.maxPending {
+			b.dropped.Add(uint64(count.Impressions + count.Clicks))
+			continue
+		}
+		b.pending[key] = count
+	}
+}
+
+func (b *Buffer) Stats() Stats {
+	b.mu.Lock()
+	pending := len(b.pending)
+	b.mu.Unlock()
+
+	return Stats{
+		Accepted:      b.accepted.Load(),
+		Dropped:       b.dropped.Load(),
+		Flushed:       b.flushed.Load(),
+		FailedFlushes: b.failedFlushes.Load(),
+		Pending:       pending,
+	}
+}
@@ -0,0 +1,71 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/mashmorsik/banners-service/pkg/models"
+)
+
+func TestBuffer(t *testing.T) {
+	day := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+	at := func(hour int) time.Time {
+		return day.Add(time.Duration(hour) * time.Hour)
+	}
+
+	var stored []models.EventCount
+	storeErr := errors.New("database is down")
+	failing := true
+	store := func(ctx context.Context, counts []models.EventCount) error {
+		if failing {
+			return storeErr
+		}
+		stored = append(stored, counts...)
+		return nil
+	}
+
+	b := NewBuffer(context.Background(), store, time.Hour, time.Second, 2)
+
+	accepted := b.Add([]models.Event{
+		{Type: models.EventImpression, BannerID: 1, Version: 2, Variant: "a", Timestamp: at(1)},
+		{Type: models.EventImpression, BannerID: 1, Version: 2, Variant: "a", Timestamp: at(23)},
+		{Type: models.EventClick, BannerID: 1, Version: 2, Variant: "a", Timestamp: at(5)},
+		{Type: models.EventImpression, BannerID: 1, Version: 3, Timestamp: at(2)},
+		// a third counter doesn't fit
+		{Type: models.EventImpression, BannerID: 1, Version: 2, Variant: "a", Timestamp: at(25)},
+	})
+	if accepted != 4 {
+		t.Errorf("Add() accepted = %d, want 4", accepted)
+	}
+
+	if err := b.Flush(); !errors.Is(err, storeErr) {
+		t.Fatalf("Flush() error = %v, want %v", err, storeErr)
+	}
+	b.Add([]models.Event{{Type: models.EventClick, BannerID: 1, Version: 3, Timestamp: at(3)}})
+
+	failing = false
+	if err := b.Flush(); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	if err := b.Flush(); err != nil {
+		t.Fatalf("Flush() of an empty buffer error = %v", err)
+	}
+
+	sort.Slice(stored, func(i, j int) bool { return stored[i].Version < stored[j].Version })
+	want := []models.EventCount{
+		{BannerID: 1, Version: 2, Variant: "a", Day: day, Impressions: 2, Clicks: 1},
+		{BannerID: 1, Version: 3, Day: day, Impressions: 1, Clicks: 1},
+	}
+	if !reflect.DeepEqual(stored, want) {
+		t.Errorf("stored counts = %+v, want %+v", stored, want)
+	}
+
+	wantStats := Stats{Accepted: 5, Dropped: 1, Flushed: 5, FailedFlushes: 1}
+	if got := b.Stats(); got != wantStats {
+		t.Errorf("Stats() = %+v, want %+v", got, wantStats)
+	}
+}
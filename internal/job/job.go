@@ -0,0 +1,131 @@
+package job
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mashmorsik/banners-service/pkg/models"
+	"github.com/mashmorsik/logger"
+	errs "github.com/pkg/errors"
+)
+
+const (
+	// saveTimeout bounds a save of the job state, the final one outlives the service ctx.
+	saveTimeout = 5 * time.Second
+	// staleAfter is how long an unfinished job may go unsaved, a running job saves its
+	// progress after every batch.
+	staleAfter = time.Minute
+)
+
+// The job error returned to clients, the cause is logged.
+const (
+	failedReason      = "job failed, see the service logs"
+	interruptedReason = "job interrupted by a shutdown of the service"
+	staleReason       = "job interrupted, its instance stopped"
+)
+
+// Func does the job work and reports the running count of affected entities.
+type Func func(ctx context.Context, report func(affected int)) (int, error)
+
+// Store keeps the state of the jobs where every instance of the service reads it.
+type Store interface {
+	SaveJob(ctx context.Context, j *models.Job) error
+	GetJob(id string) (*models.Job, error)
+	PurgeJobs(finishedBefore time.Time) (int, error)
+	FailStaleJobs(updatedBefore time.Time, reason string) (int, error)
+}
+
+// Manager runs background jobs on this instance and keeps their state in the store, so
+// any instance answers for a job. Finished jobs are purged once they are older than ttl.
+// Jobs stop with the ctx and save their final state, a job left unfinished by a crashed
+// instance is failed by FailStale.
+type Manager struct {
+	Ctx   context.Context
+	ttl   time.Duration
+	store Store
+	wg    sync.WaitGroup
+}
+
+func NewManager(ctx context.Context, store Store, ttl time.Duration) *Manager {
+	return &Manager{Ctx: ctx, ttl: ttl, store: store}
+}
+
+// Start stores a new job and runs it in the background, the returned job is a snapshot.
+func (m *Manager) Start(jobType string, run Func) (*models.Job, error) {
+	if m.ttl > 0 {
+		if _, err := m.store.PurgeJobs(time.Now().Add(-m.ttl)); err != nil {
+			logger.Errf("fail to purge finished jobs: %s", err)
+		}
+	}
+
+	j := &models.Job{
+		ID:        uuid.NewString(),
+		Type:      jobType,
+		Status:    models.JobStatusPending,
+		CreatedAt: time.Now(),
+	}
+	if err := m.store.SaveJob(m.Ctx, j); err != nil {
+		return nil, errs.WithMessagef(err, "fail to start %s job", jobType)
+	}
+	snapshot := *j
+
+	m.wg.Add(1)
+	go m.run(j, run)
+
+	return &snapshot, nil
+}
+
+func (m *Manager) Get(id string) (*models.Job, error) {
+	return m.store.GetJob(id)
+}
+
+// Wait blocks until the jobs of this instance have saved their final state.
+func (m *Manager) Wait() {
+	m.wg.Wait()
+}
+
+// FailStale fails the unfinished jobs that no instance has saved for a while, it is run on
+// startup.
+func (m *Manager) FailStale() (int, error) {
+	return m.store.FailStaleJobs(time.Now().Add(-staleAfter), staleReason)
+}
+
+// run owns the job, it is the only writer of its state.
+func (m *Manager) run(j *models.Job, run Func) {
+	defer m.wg.Done()
+
+	j.Status = models.JobStatusRunning
+	m.save(j)
+
+	affected, err := run(m.Ctx, func(affected int) {
+		j.Affected = affected
+		m.save(j)
+	})
+
+	finishedAt := time.Now()
+	j.FinishedAt = &finishedAt
+	j.Affected = affected
+	j.Status = models.JobStatusDone
+	if err != nil {
+		logger.Errf("job %s %s failed: %s", j.Type, j.ID, err)
+		j.Status = models.JobStatusFailed
+		j.Error = failedReason
+		if m.Ctx.Err() != nil {
+			j.Error = interruptedReason
+		}
+	}
+	m.save(j)
+}
+
+// save logs a failed write, the next one brings the stored state up to date. The state is
+// saved after the ctx is done too, so a job stopped by a shutdown isn't left running.
+func (m *Manager) save(j *models.Job) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(m.Ctx), saveTimeout)
+	defer cancel()
+
+	if err := m.store.SaveJob(ctx, j); err != nil {
+		logger.Errf("fail to save job %s %s: %s", j.Type, j.ID, err)
+	}
+}
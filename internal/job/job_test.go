@@ -0,0 +1,106 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mashmorsik/banners-service/pkg/models"
+	"github.com/mashmorsik/logger"
+)
+
+// memStore keeps the last saved state of every job and fails saves with a done ctx, as
+// the database driver does.
+type memStore struct {
+	mu   sync.Mutex
+	jobs map[string]models.Job
+}
+
+func (s *memStore) SaveJob(ctx context.Context, j *models.Job) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs[j.ID] = *j
+	return nil
+}
+
+func (s *memStore) GetJob(id string) (*models.Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j := s.jobs[id]
+	return &j, nil
+}
+
+func (s *memStore) PurgeJobs(time.Time) (int, error) {
+	return 0, nil
+}
+
+func (s *memStore) FailStaleJobs(time.Time, string) (int, error) {
+	return 0, nil
+}
+
+func TestManager(t *testing.T) {
+	logger.BuildLogger(nil)
+
+	tests := []struct {
+		name      string
+		run       Func
+		shutdown  bool
+		want      models.JobStatus
+		wantError string
+	}{
+		{
+			name: "done",
+			run: func(ctx context.Context, report func(affected int)) (int, error) {
+				report(1)
+				return 2, nil
+			},
+			want: models.JobStatusDone,
+		},
+		{
+			name: "failed_hides_the_cause",
+			run: func(ctx context.Context, report func(affected int)) (int, error) {
+				return 1, errors.New(`pq: relation "banner" does not exist`)
+			},
+			want:      models.JobStatusFailed,
+			wantError: failedReason,
+		},
+		{
+			name: "interrupted_by_shutdown",
+			run: func(ctx context.Context, report func(affected int)) (int, error) {
+				<-ctx.Done()
+				return 1, ctx.Err()
+			},
+			shutdown:  true,
+			want:      models.JobStatusFailed,
+			wantError: interruptedReason,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			store := &memStore{jobs: map[string]models.Job{}}
+			m := NewManager(ctx, store, 0)
+
+			started, err := m.Start("test", tt.run)
+			if err != nil {
+				t.Fatalf("Start() error = %v", err)
+			}
+			if tt.shutdown {
+				cancel()
+			}
+			m.Wait()
+
+			got, _ := m.Get(started.ID)
+			if got.Status != tt.want || got.Error != tt.wantError || got.FinishedAt == nil {
+				t.Errorf("Get() = %+v, want status %s and error %q", got, tt.want, tt.wantError)
+			}
+		})
+	}
+}
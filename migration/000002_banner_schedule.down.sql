@@ -0,0 +1,4 @@
+alter table public.banner
+    drop constraint if exists banner_schedule_check,
+    drop column if exists starts_at,
+    drop column if exists ends_at;
@@ -0,0 +1,4 @@
+alter table public.banner
+    add column if not exists starts_at timestamp with time zone,
+    add column if not exists ends_at timestamp with time zone,
+    add constraint banner_schedule_check check (starts_at is null or ends_at is null or ends_at > starts_at);
@@ -0,0 +1,4 @@
+drop index if exists public.banner_deleted_at_idx;
+
+alter table public.banner
+    drop column if exists deleted_at;
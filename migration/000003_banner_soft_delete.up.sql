@@ -0,0 +1,4 @@
+alter table public.banner
+    add column if not exists deleted_at timestamp with time zone;
+
+create index if not exists banner_deleted_at_idx on public.banner (deleted_at) where deleted_at is not null;
@@ -0,0 +1 @@
+drop index if exists public.banner_updated_at_id_idx;
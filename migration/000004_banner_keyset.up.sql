@@ -0,0 +1 @@
+create index if not exists banner_updated_at_id_idx on public.banner (updated_at desc, id desc) where deleted_at is null;
@@ -0,0 +1 @@
+drop index if exists public.banner_content_search_idx;
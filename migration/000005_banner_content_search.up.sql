@@ -0,0 +1,3 @@
+create index if not exists banner_content_search_idx on public.banner_content using gin ((
+    setweight(to_tsvector('simple', coalesce(content ->> 'title', '')), 'A') ||
+    setweight(to_tsvector('simple', coalesce(content ->> 'text', '')), 'B')));
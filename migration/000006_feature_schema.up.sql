@@ -0,0 +1,8 @@
+create table if not exists public.feature_schema
+(
+    feature_id integer not null references public.feature (id) on delete cascade,
+    version integer not null,
+    schema jsonb not null,
+    created_at timestamp with time zone not null,
+    primary key (feature_id, version)
+);
@@ -0,0 +1,11 @@
+alter table public.tag
+    drop column if exists description,
+    drop column if exists archived,
+    drop column if exists created_at,
+    drop column if exists updated_at;
+
+alter table public.feature
+    drop column if exists description,
+    drop column if exists archived,
+    drop column if exists created_at,
+    drop column if exists updated_at;
@@ -0,0 +1,11 @@
+alter table public.tag
+    add column if not exists description text not null default '',
+    add column if not exists archived bool not null default false,
+    add column if not exists created_at timestamp with time zone not null default now(),
+    add column if not exists updated_at timestamp with time zone not null default now();
+
+alter table public.feature
+    add column if not exists description text not null default '',
+    add column if not exists archived bool not null default false,
+    add column if not exists created_at timestamp with time zone not null default now(),
+    add column if not exists updated_at timestamp with time zone not null default now();
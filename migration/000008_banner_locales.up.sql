@@ -0,0 +1,2 @@
+alter table public.banner_content
+    add column if not exists locales jsonb not null default '{}';
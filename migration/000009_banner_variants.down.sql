@@ -0,0 +1,2 @@
+alter table public.banner_content
+    drop column if exists variants;
@@ -0,0 +1,2 @@
+alter table public.banner_content
+    add column if not exists variants jsonb not null default '[]';
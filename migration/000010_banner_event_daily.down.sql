@@ -0,0 +1 @@
+drop table if exists public.banner_event_daily;
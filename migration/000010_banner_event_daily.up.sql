@@ -0,0 +1,10 @@
+create table if not exists public.banner_event_daily
+(
+    banner_id   integer not null references public.banner (id) on delete cascade,
+    version     integer not null,
+    variant     text    not null default '',
+    day         date    not null,
+    impressions bigint  not null default 0,
+    clicks      bigint  not null default 0,
+    primary key (banner_id, day, version, variant)
+);
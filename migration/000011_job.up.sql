@@ -0,0 +1,12 @@
+create table if not exists public.job
+(
+    id          uuid                     not null primary key,
+    type        text                     not null,
+    status      text                     not null,
+    affected    integer                  not null default 0,
+    error       text                     not null default '',
+    created_at  timestamp with time zone not null,
+    finished_at timestamp with time zone
+);
+
+create index if not exists job_finished_at_idx on public.job (finished_at) where finished_at is not null;
@@ -0,0 +1,2 @@
+alter table public.banner
+    drop column if exists revision;
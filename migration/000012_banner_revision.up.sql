@@ -0,0 +1,2 @@
+alter table public.banner
+    add column if not exists revision integer not null default 1;
@@ -0,0 +1,2 @@
+alter table public.job
+    drop column if exists updated_at;
@@ -0,0 +1,2 @@
+alter table public.job
+    add column if not exists updated_at timestamp with time zone not null default now();
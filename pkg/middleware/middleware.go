@@ -1,11 +1,14 @@
 package middleware
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"time"
 
 	"github.com/go-openapi/runtime"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/mashmorsik/banners-service/pkg/token"
 	"github.com/mashmorsik/logger"
 )
@@ -21,32 +24,10 @@ func LoggingMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// AdminAuthMiddleware lets through requests with a valid token of the admin role.
 func AdminAuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		h, ok := r.Header[runtime.HeaderAuthorization]
-		if !ok {
-			http.Error(w, fmt.Sprintf("%s header is missing ", runtime.HeaderAuthorization), http.StatusForbidden)
-			return
-		}
-		if len(h) == 0 {
-			http.Error(w, fmt.Sprintf("%s header is empty", runtime.HeaderAuthorization), http.StatusForbidden)
-			return
-		}
-
-		claims, err := token.Validate(h[0])
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusForbidden)
-			return
-		}
-
-		roles, err := token.GetRoles(claims)
-		if err != nil {
-			http.Error(w, fmt.Sprint("get auth roles failed"), http.StatusForbidden)
-			return
-		}
-
-		if !token.IsAdmin(roles) {
-			http.Error(w, fmt.Sprintf("%s role is missing", token.RoleAdmin), http.StatusForbidden)
+		if _, ok := authorize(w, r, token.RoleAdmin, token.IsAdmin); !ok {
 			return
 		}
 
@@ -54,35 +35,74 @@ func AdminAuthMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// UserAuthMiddleware lets through requests with a valid token of the user role, the user of
+// the token is put into the request context.
 func UserAuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		h, ok := r.Header[runtime.HeaderAuthorization]
+		claims, ok := authorize(w, r, token.RoleUser, token.IsUser)
 		if !ok {
-			http.Error(w, fmt.Sprintf("%s header is missing ", runtime.HeaderAuthorization), http.StatusForbidden)
-			return
-		}
-		if len(h) == 0 {
-			http.Error(w, fmt.Sprintf("%s header is empty", runtime.HeaderAuthorization), http.StatusForbidden)
-			return
-		}
-
-		claims, err := token.Validate(h[0])
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusForbidden)
 			return
 		}
 
-		roles, err := token.GetRoles(claims)
-		if err != nil {
-			http.Error(w, fmt.Sprint("get auth roles failed"), http.StatusForbidden)
-			return
-		}
-
-		if !token.IsUser(roles) {
-			http.Error(w, fmt.Sprintf("%s role is missing", token.RoleUser), http.StatusForbidden)
-			return
+		if userID := token.GetUserID(claims); userID != "" {
+			r = r.WithContext(context.WithValue(r.Context(), userIDKey{}, userID))
 		}
 
 		next.ServeHTTP(w, r)
 	})
 }
+
+// authorize validates the token of the request and checks its roles. A missing or invalid
+// token is answered with 401, a token without the role with 403. It writes the error
+// response itself and returns false on failure.
+func authorize(w http.ResponseWriter, r *http.Request, role token.Role, hasRole func([]string) bool) (jwt.MapClaims, bool) {
+	h := r.Header.Get(runtime.HeaderAuthorization)
+	if h == "" {
+		writeError(w, http.StatusUnauthorized, fmt.Sprintf("%s header is missing", runtime.HeaderAuthorization))
+		return nil, false
+	}
+
+	claims, err := token.Validate(h)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, err.Error())
+		return nil, false
+	}
+
+	roles, err := token.GetRoles(claims)
+	if err != nil || !hasRole(roles) {
+		writeError(w, http.StatusForbidden, fmt.Sprintf("%s role is missing", role))
+		return nil, false
+	}
+
+	return claims, true
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// writeError answers with the JSON error body the handlers use.
+func writeError(w http.ResponseWriter, status int, message string) {
+	jsonData, err := json.Marshal(errorResponse{Error: message})
+	if err != nil {
+		logger.Errf("failed to marshal JSON: %v", err)
+		http.Error(w, message, status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	if _, err = w.Write(jsonData); err != nil {
+		logger.Errf("failed to write response: %v", err)
+	}
+}
+
+type userIDKey struct{}
+
+// UserID returns the user the request was authorized for by UserAuthMiddleware, empty when
+// the token has no user.
+func UserID(ctx context.Context) string {
+	userID, _ := ctx.Value(userIDKey{}).(string)
+	return userID
+}
@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mashmorsik/banners-service/pkg/token"
+)
+
+func TestAuthMiddleware(t *testing.T) {
+	token.NewTokenManager("test-secret")
+	adminToken, err := token.Create(token.RoleAdmin)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	userToken, err := token.Create(token.RoleUser)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	tests := []struct {
+		name       string
+		middleware func(http.Handler) http.Handler
+		token      string
+		wantStatus int
+	}{
+		{name: "admin_token", middleware: AdminAuthMiddleware, token: adminToken, wantStatus: http.StatusNoContent},
+		{name: "missing_token", middleware: AdminAuthMiddleware, wantStatus: http.StatusUnauthorized},
+		{name: "invalid_token", middleware: AdminAuthMiddleware, token: "not-a-jwt", wantStatus: http.StatusUnauthorized},
+		{name: "user_token_on_admin_route", middleware: AdminAuthMiddleware, token: userToken, wantStatus: http.StatusForbidden},
+		{name: "user_token", middleware: UserAuthMiddleware, token: userToken, wantStatus: http.StatusNoContent},
+		{name: "admin_token_on_user_route", middleware: UserAuthMiddleware, token: adminToken, wantStatus: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.token != "" {
+				r.Header.Set("Authorization", tt.token)
+			}
+			w := httptest.NewRecorder()
+
+			tt.middleware(next).ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if w.Code == http.StatusNoContent {
+				return
+			}
+
+			var body errorResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.Error == "" {
+				t.Errorf("body = %s, want a JSON error", w.Body.String())
+			}
+			if contentType := w.Header().Get("Content-Type"); contentType != "application/json" {
+				t.Errorf("Content-Type = %s, want application/json", contentType)
+			}
+		})
+	}
+}
@@ -1,21 +1,197 @@
 package models
 
-import "time"
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+)
 
 type Banner struct {
 	ID        int   `json:"id"`
 	TagIDs    []int `json:"tag_ids"`
 	FeatureID int   `json:"feature_id"`
-	IsActive  bool  `json:"is_active"`
+	// FeatureName and TagNames are the catalog names, TagNames follows the order of TagIDs.
+	FeatureName string   `json:"feature_name,omitempty"`
+	TagNames    []string `json:"tag_names,omitempty"`
+	IsActive    bool     `json:"is_active"`
 	//Latest    bool      `json:"use_latest_revision"`
-	Content   Content   `json:"content"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
-	Version   int       `json:"version"`
+	Content Content `json:"content"`
+	// Locales holds the content translated to other locales, the default Content is
+	// served when none of the requested locales is there.
+	Locales map[string]Content `json:"locales,omitempty"`
+	// Variants split the users of the banner between contents of an A/B test, users
+	// without an id are served the default content.
+	Variants  []Variant  `json:"variants,omitempty"`
+	StartsAt  *time.Time `json:"starts_at"`
+	EndsAt    *time.Time `json:"ends_at"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	Version   int        `json:"version"`
+	// Revision counts the writes to the banner, any version, locale or activation change
+	// bumps it. Its quoted value is the ETag of the banner and is expected in If-Match.
+	Revision int `json:"revision"`
+	// ClearStartsAt and ClearEndsAt mark an explicit null in an update, it opens the bound
+	// while a missing field keeps the stored one.
+	ClearStartsAt bool `json:"-"`
+	ClearEndsAt   bool `json:"-"`
 }
 
-type Content struct {
-	Title string `json:"title"`
-	Text  string `json:"text"`
-	URL   string `json:"url"`
+func (b *Banner) UnmarshalJSON(data []byte) error {
+	type banner Banner
+	if err := json.Unmarshal(data, (*banner)(b)); err != nil {
+		return err
+	}
+
+	var schedule struct {
+		StartsAt json.RawMessage `json:"starts_at"`
+		EndsAt   json.RawMessage `json:"ends_at"`
+	}
+	if err := json.Unmarshal(data, &schedule); err != nil {
+		return err
+	}
+	b.ClearStartsAt = string(schedule.StartsAt) == "null"
+	b.ClearEndsAt = string(schedule.EndsAt) == "null"
+
+	return nil
+}
+
+// LocalizedContent returns the content of the first locale of the chain the banner has,
+// the default content when it has none of them.
+func (b *Banner) LocalizedContent(locales []string) Content {
+	return localized(b.Content, b.Locales, locales)
+}
+
+// LocalizedVariants returns the variants with the content in the given locales, the
+// locales themselves are dropped.
+func (b *Banner) LocalizedVariants(locales []string) []Variant {
+	if len(b.Variants) == 0 {
+		return nil
+	}
+
+	variants := make([]Variant, len(b.Variants))
+	for i, variant := range b.Variants {
+		variants[i] = Variant{
+			Key:     variant.Key,
+			Weight:  variant.Weight,
+			Content: localized(variant.Content, variant.Locales, locales),
+		}
+	}
+
+	return variants
+}
+
+// UserBanner is the content served to a user with the banner version and variant it
+// comes from, clients report them in events.
+type UserBanner struct {
+	BannerID int
+	Version  int
+	Variant  string
+	Content  Content
+}
+
+// Variant is one content of an A/B test, a user gets it with the probability of its weight
+// divided by the total weight of the banner variants.
+type Variant struct {
+	Key     string             `json:"key"`
+	Weight  int                `json:"weight"`
+	Content Content            `json:"content"`
+	Locales map[string]Content `json:"locales,omitempty"`
+}
+
+func localized(content Content, translations map[string]Content, locales []string) Content {
+	for _, locale := range locales {
+		if translated, ok := translations[locale]; ok {
+			return translated
+		}
+	}
+
+	return content
+}
+
+// Content is a free-form JSON object, title, text and url are the fields known to the service.
+// Numbers are kept as json.Number, so they are written back exactly as they were received.
+type Content map[string]any
+
+func (c *Content) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var content map[string]any
+	if err := dec.Decode(&content); err != nil {
+		return err
+	}
+	*c = content
+
+	return nil
+}
+
+// Merge applies the patch as a JSON merge patch (RFC 7386) and returns the result, c is
+// not modified. Objects are merged recursively, a null value removes the field and any
+// other value, arrays included, replaces it.
+func (c Content) Merge(patch Content) Content {
+	return mergeObjects(c, patch)
+}
+
+func mergeObjects(target, patch map[string]any) map[string]any {
+	merged := make(map[string]any, len(target)+len(patch))
+	for key, value := range target {
+		merged[key] = value
+	}
+
+	for key, value := range patch {
+		if value == nil {
+			delete(merged, key)
+			continue
+		}
+
+		patchObject, isObject := value.(map[string]any)
+		if !isObject {
+			merged[key] = value
+			continue
+		}
+		targetObject, _ := merged[key].(map[string]any)
+		merged[key] = mergeObjects(targetObject, patchObject)
+	}
+
+	return merged
+}
+
+type BannerDiff struct {
+	BannerID      int       `json:"banner_id"`
+	From          int       `json:"from"`
+	To            int       `json:"to"`
+	AddedTagIDs   []int     `json:"added_tag_ids"`
+	RemovedTagIDs []int     `json:"removed_tag_ids"`
+	Patch         []PatchOp `json:"patch"`
+	Summary       []string  `json:"summary"`
+}
+
+// PatchOp is a single RFC 6902 JSON Patch operation.
+type PatchOp struct {
+	Op    string `json:"op"`
+	Path  string `json:"path"`
+	Value any    `json:"value,omitempty"`
+}
+
+type PruneResult struct {
+	BannerID int `json:"banner_id"`
+	Pruned   int `json:"pruned"`
+}
+
+type FeatureTag struct {
+	FeatureID int `json:"feature_id"`
+	TagID     int `json:"tag_id"`
+}
+
+// BannerChange is the payload of a banner change notification. Flush is set when the
+// changed pairs do not fit into a notification and every cached banner must be dropped.
+type BannerChange struct {
+	BannerIDs []int        `json:"banner_ids"`
+	Pairs     []FeatureTag `json:"pairs"`
+	Flush     bool         `json:"flush"`
 }
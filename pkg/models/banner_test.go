@@ -0,0 +1,110 @@
+package models
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestContent_Merge(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		patch  string
+		want   string
+	}{
+		{
+			name:   "empty_patch_keeps_content",
+			target: `{"title": "title", "colors": {"text": "#000"}}`,
+			patch:  `{}`,
+			want:   `{"title": "title", "colors": {"text": "#000"}}`,
+		},
+		{
+			name:   "objects_are_merged_recursively",
+			target: `{"title": "title", "colors": {"text": "#000", "background": "#fff"}}`,
+			patch:  `{"colors": {"background": "#eee", "border": "#ccc"}}`,
+			want:   `{"title": "title", "colors": {"text": "#000", "background": "#eee", "border": "#ccc"}}`,
+		},
+		{
+			name:   "null_removes_field",
+			target: `{"title": "title", "colors": {"text": "#000", "background": "#fff"}}`,
+			patch:  `{"title": null, "colors": {"text": null}}`,
+			want:   `{"colors": {"background": "#fff"}}`,
+		},
+		{
+			name:   "arrays_and_scalars_are_replaced",
+			target: `{"images": ["a.png", "b.png"], "colors": "#000", "priority": 1}`,
+			patch:  `{"images": ["c.png"], "colors": {"text": "#000"}, "priority": 12345678901234567890}`,
+			want:   `{"images": ["c.png"], "colors": {"text": "#000"}, "priority": 12345678901234567890}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var target, patch, want Content
+			for _, c := range []struct {
+				raw string
+				dst *Content
+			}{{tt.target, &target}, {tt.patch, &patch}, {tt.want, &want}} {
+				if err := json.Unmarshal([]byte(c.raw), c.dst); err != nil {
+					t.Fatalf("Unmarshal(%s) error = %v", c.raw, err)
+				}
+			}
+			targetBefore, _ := json.Marshal(target)
+
+			got := target.Merge(patch)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Merge() got = %v, want %v", got, want)
+			}
+			if targetAfter, _ := json.Marshal(target); string(targetAfter) != string(targetBefore) {
+				t.Errorf("Merge() modified the target: %s, was %s", targetAfter, targetBefore)
+			}
+		})
+	}
+}
+
+func TestContent_UnmarshalJSON(t *testing.T) {
+	var banner Banner
+	err := json.Unmarshal([]byte(`{"content": {"id": 9007199254740993, "ratio": 0.1}}`), &banner)
+	if err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+
+	data, err := json.Marshal(banner.Content)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	if want := `{"id":9007199254740993,"ratio":0.1}`; string(data) != want {
+		t.Errorf("Marshal() got = %s, want %s", data, want)
+	}
+
+	if err = json.Unmarshal([]byte(`{"content": ["not", "an", "object"]}`), &banner); err == nil {
+		t.Errorf("Unmarshal() of an array content error = nil, want error")
+	}
+}
+
+func TestBanner_UnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name                           string
+		body                           string
+		wantClearStarts, wantClearEnds bool
+		wantEnds                       bool
+	}{
+		{name: "missing_schedule_is_kept", body: `{"content": {}}`},
+		{name: "null_clears_bound", body: `{"starts_at": null}`, wantClearStarts: true},
+		{name: "value_sets_bound", body: `{"starts_at": null, "ends_at": "2024-04-01T00:00:00Z"}`,
+			wantClearStarts: true, wantEnds: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var banner Banner
+			if err := json.Unmarshal([]byte(tt.body), &banner); err != nil {
+				t.Fatalf("Unmarshal() error = %v", err)
+			}
+			if banner.ClearStartsAt != tt.wantClearStarts || banner.ClearEndsAt != tt.wantClearEnds ||
+				(banner.EndsAt != nil) != tt.wantEnds {
+				t.Errorf("Unmarshal() got starts_at = %v (clear %v), ends_at = %v (clear %v)",
+					banner.StartsAt, banner.ClearStartsAt, banner.EndsAt, banner.ClearEndsAt)
+			}
+		})
+	}
+}
@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+// CatalogKind names a catalog of banner attributes, it is also the name of its table.
+type CatalogKind string
+
+const (
+	CatalogTags     CatalogKind = "tag"
+	CatalogFeatures CatalogKind = "feature"
+)
+
+// CatalogEntry is a tag or a feature with a human readable name. Archived entries stay
+// referenced by existing banners and are hidden from listings.
+type CatalogEntry struct {
+	ID          int       `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Archived    bool      `json:"archived"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// CatalogPatch changes the fields of an entry that are set.
+type CatalogPatch struct {
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+	Archived    *bool   `json:"archived"`
+}
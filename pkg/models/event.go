@@ -0,0 +1,61 @@
+package models
+
+import "time"
+
+type EventType string
+
+const (
+	EventImpression EventType = "impression"
+	EventClick      EventType = "click"
+)
+
+// Event is an impression or a click reported by a client for the banner version and
+// variant it was served in the X-Banner-* headers of GET /user_banner.
+type Event struct {
+	Type      EventType `json:"type"`
+	BannerID  int       `json:"banner_id"`
+	Version   int       `json:"version"`
+	Variant   string    `json:"variant,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// EventCount is the number of events of a banner version and variant in a UTC day.
+type EventCount struct {
+	BannerID    int
+	Version     int
+	Variant     string
+	Day         time.Time
+	Impressions int64
+	Clicks      int64
+}
+
+// DailyStats are the counts of a banner version and variant in a UTC day, CTR is the
+// share of impressions that were clicked.
+type DailyStats struct {
+	Day         string  `json:"day"`
+	Version     int     `json:"version"`
+	Variant     string  `json:"variant,omitempty"`
+	Impressions int64   `json:"impressions"`
+	Clicks      int64   `json:"clicks"`
+	CTR         float64 `json:"ctr"`
+}
+
+// BannerStats are the daily counts of a banner between From and To, both days included.
+type BannerStats struct {
+	BannerID    int          `json:"banner_id"`
+	From        string       `json:"from"`
+	To          string       `json:"to"`
+	Impressions int64        `json:"impressions"`
+	Clicks      int64        `json:"clicks"`
+	CTR         float64      `json:"ctr"`
+	Days        []DailyStats `json:"days"`
+}
+
+// CTR returns the share of impressions that were clicked, zero without impressions.
+func CTR(impressions, clicks int64) float64 {
+	if impressions <= 0 {
+		return 0
+	}
+
+	return float64(clicks) / float64(impressions)
+}
@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+type TagMatch string
+
+const (
+	// TagMatchAny matches versions having at least one of the tags.
+	TagMatchAny TagMatch = "any"
+	// TagMatchAll matches versions having every tag.
+	TagMatchAll TagMatch = "all"
+)
+
+// BannerFilter selects banners for the admin list, zero fields don't filter. FeatureID,
+// TagIDs and Query match banner versions, the list holds the matching versions only.
+type BannerFilter struct {
+	FeatureID   int
+	TagIDs      []int
+	TagMatch    TagMatch
+	IsActive    *bool
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time
+	UpdatedFrom *time.Time
+	UpdatedTo   *time.Time
+	// Query is searched in the title and the text of the content, case insensitive.
+	Query string
+}
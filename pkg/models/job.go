@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+type JobStatus string
+
+const (
+	JobStatusPending JobStatus = "pending"
+	JobStatusRunning JobStatus = "running"
+	JobStatusDone    JobStatus = "done"
+	JobStatusFailed  JobStatus = "failed"
+)
+
+type Job struct {
+	ID         string     `json:"id"`
+	Type       string     `json:"type"`
+	Status     JobStatus  `json:"status"`
+	Affected   int        `json:"affected"`
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
@@ -0,0 +1,87 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"time"
+
+	errs "github.com/pkg/errors"
+)
+
+type SortField string
+
+const (
+	SortByID        SortField = "id"
+	SortByUpdatedAt SortField = "updated_at"
+	SortByFeatureID SortField = "feature_id"
+	// SortByRank orders search results by relevance.
+	SortByRank SortField = "rank"
+)
+
+// Sort orders banners by the field and then by id in the same direction. The zero value
+// falls back to the newest updated banners first.
+type Sort struct {
+	Field SortField
+	Desc  bool
+}
+
+func (s Sort) OrDefault() Sort {
+	if s.Field == "" {
+		return Sort{Field: SortByUpdatedAt, Desc: true}
+	}
+	return s
+}
+
+// Page selects banners in the sort order, either by offset or after the cursor returned
+// with the previous page. A zero limit returns every banner.
+type Page struct {
+	Limit  int
+	Offset int
+	Cursor *Cursor
+	Sort   Sort
+}
+
+// Cursor points at the last banner of a page, it is only valid for the sort it was made for.
+type Cursor struct {
+	Sort      SortField `json:"sort"`
+	Desc      bool      `json:"desc"`
+	UpdatedAt time.Time `json:"updated_at,omitempty"`
+	FeatureID int       `json:"feature_id,omitempty"`
+	Rank      float64   `json:"rank,omitempty"`
+	ID        int       `json:"id"`
+}
+
+// Matches tells whether the cursor may continue a listing in the given sort.
+func (c *Cursor) Matches(sort Sort) bool {
+	return c.Sort == sort.Field && c.Desc == sort.Desc
+}
+
+// BannerPage holds the versions of a page of banners, Total counts every matching banner.
+type BannerPage struct {
+	Banners    []*Banner
+	Total      int
+	NextCursor *Cursor
+}
+
+// Encode makes an opaque cursor for clients.
+func (c *Cursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func DecodeCursor(cursor string) (*Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, errs.WithMessage(err, "fail to decode cursor")
+	}
+
+	var c Cursor
+	if err = json.Unmarshal(data, &c); err != nil {
+		return nil, errs.WithMessage(err, "fail to unmarshal cursor")
+	}
+	if c.ID <= 0 || c.Sort == SortByUpdatedAt && c.UpdatedAt.IsZero() {
+		return nil, errs.New("cursor is incomplete")
+	}
+
+	return &c, nil
+}
@@ -0,0 +1,20 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// FeatureSchema is a version of the JSON Schema that content of the feature banners must match.
+type FeatureSchema struct {
+	FeatureID int             `json:"feature_id"`
+	Version   int             `json:"version"`
+	Schema    json.RawMessage `json:"schema"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// FieldError tells which request field was rejected and why.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
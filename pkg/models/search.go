@@ -0,0 +1,22 @@
+package models
+
+// SearchHit is the best matching version of a banner.
+type SearchHit struct {
+	Banner
+	Rank    float64 `json:"rank"`
+	Snippet Snippet `json:"snippet"`
+}
+
+// Snippet holds the matched content as HTML: the content is escaped and every match is wrapped
+// in <mark> tags.
+type Snippet struct {
+	Title string `json:"title"`
+	Text  string `json:"text"`
+}
+
+// SearchPage holds a page of search hits, Total counts every matching banner.
+type SearchPage struct {
+	Hits       []*SearchHit
+	Total      int
+	NextCursor *Cursor
+}
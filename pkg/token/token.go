@@ -99,6 +99,17 @@ func GetRoles(m jwt.MapClaims) ([]string, error) {
 	return parseString(m, "roles")
 }
 
+// GetUserID returns the subject of a token issued to a user, tokens made by /token name
+// their role in the subject and have no user.
+func GetUserID(m jwt.MapClaims) string {
+	subject, err := m.GetSubject()
+	if err != nil || subject == string(RoleAdmin) || subject == string(RoleUser) {
+		return ""
+	}
+
+	return subject
+}
+
 // parseString tries to parse a key in the map claims type as a [string] type.
 // If the key does not exist, an empty string is returned. If the key has the
 // wrong type, an error is returned.
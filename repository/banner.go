@@ -4,7 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
-	"strconv"
+	"slices"
 	"time"
 
 	"github.com/lib/pq"
@@ -27,18 +27,23 @@ func (br *BannerRepo) GetForUser(b *models.Banner) (*models.Banner, error) {
 	ctx, cancel := context.WithTimeout(br.Ctx, time.Second*5)
 	defer cancel()
 
-	var contentJSON []byte
+	var contentJSON, localesJSON, variantsJSON []byte
 	var banner models.Banner
 
 	err := br.data.Master().QueryRowContext(ctx,
-		`SELECT bc.content, bc.banner_id
+		`SELECT bc.content, bc.locales, bc.variants, bc.banner_id, bc.version, b.starts_at, b.ends_at
 		FROM banner_content bc
 		JOIN banner b ON bc.banner_id = b.id
 		JOIN banner_feature_tag bft ON b.id = bft.banner_id
 		WHERE bft.tag_id = $1
 		AND bft.feature_id = $2
 		AND b.is_active = true
-		AND b.active_version = bc.version`, b.TagIDs[0], b.FeatureID).Scan(&contentJSON, &banner.ID)
+		AND b.active_version = bc.version
+		AND bft.version = bc.version
+		AND b.deleted_at IS NULL
+		AND (b.starts_at IS NULL OR b.starts_at <= now())
+		AND (b.ends_at IS NULL OR b.ends_at > now())`, b.TagIDs[0], b.FeatureID).Scan(&contentJSON, &localesJSON,
+		&variantsJSON, &banner.ID, &banner.Version, &banner.StartsAt, &banner.EndsAt)
 	if err != nil {
 		return nil, errs.WithMessagef(err, "failed to get banner content with bannerID %d", b.ID)
 	}
@@ -46,76 +51,200 @@ func (br *BannerRepo) GetForUser(b *models.Banner) (*models.Banner, error) {
 	if err = json.Unmarshal(contentJSON, &banner.Content); err != nil {
 		return nil, errs.WithMessagef(err, "failed to unmarshal content with bannerID %d", b.ID)
 	}
+	if err = json.Unmarshal(localesJSON, &banner.Locales); err != nil {
+		return nil, errs.WithMessagef(err, "failed to unmarshal locales with bannerID %d", b.ID)
+	}
+	if err = json.Unmarshal(variantsJSON, &banner.Variants); err != nil {
+		return nil, errs.WithMessagef(err, "failed to unmarshal variants with bannerID %d", b.ID)
+	}
 
 	return &banner, nil
 }
 
-func (br *BannerRepo) GetForAdmin(b *models.Banner, limit, offset int) ([]*models.Banner, error) {
-	var banners []*models.Banner
+// adminSortColumns lists the sort keys of the admin list with the SQL type of the key.
+var adminSortColumns = map[models.SortField]struct{ expr, cast string }{
+	models.SortByID:        {expr: "b.id", cast: "int"},
+	models.SortByUpdatedAt: {expr: "b.updated_at", cast: "timestamptz"},
+	models.SortByFeatureID: {
+		expr: `(SELECT f.feature_id FROM banner_feature_tag f
+			WHERE f.banner_id = b.id AND f.version = b.last_version LIMIT 1)`,
+		cast: "int",
+	},
+}
+
+// GetForAdmin returns the matching versions of a page of banners as one row per tag.
+// Banners are paged rather than rows, so a page always holds the requested number of banners.
+func (br *BannerRepo) GetForAdmin(filter models.BannerFilter, page models.Page) (*models.BannerPage, error) {
+	ctx, cancel := context.WithTimeout(br.Ctx, time.Second*5)
+	defer cancel()
 
-	var queryLimit, queryOffset, queryTag, queryFeature interface{}
-	if limit != 0 {
-		queryLimit = limit
+	sort := page.Sort.OrDefault()
+	sortColumn, ok := adminSortColumns[sort.Field]
+	if !ok {
+		return nil, errs.Errorf("unknown sort field: %s", sort.Field)
 	}
-	if offset != 0 {
-		queryOffset = offset
+	direction, compare := "ASC", ">"
+	if sort.Desc {
+		direction, compare = "DESC", "<"
 	}
-	if b.TagIDs[0] != 0 {
-		queryTag = b.TagIDs[0]
+
+	q := &queryBuilder{}
+
+	// conditions on a version, bc is its content
+	if filter.FeatureID != 0 {
+		q.where(`EXISTS (
+		SELECT 1 FROM banner_feature_tag f
+		WHERE f.banner_id = bc.banner_id AND f.version = bc.version AND f.feature_id = %s)`, filter.FeatureID)
+	}
+	if len(filter.TagIDs) > 0 && filter.TagMatch == models.TagMatchAll {
+		q.where(`(SELECT array_agg(f.tag_id) FROM banner_feature_tag f
+		WHERE f.banner_id = bc.banner_id AND f.version = bc.version) @> %s::int[]`, pq.Array(filter.TagIDs))
+	} else if len(filter.TagIDs) > 0 {
+		q.where(`EXISTS (
+		SELECT 1 FROM banner_feature_tag f
+		WHERE f.banner_id = bc.banner_id AND f.version = bc.version AND f.tag_id = ANY(%s::int[]))`,
+			pq.Array(filter.TagIDs))
 	}
-	if b.FeatureID != 0 {
-		queryFeature = b.FeatureID
+	if filter.Query != "" {
+		q.where(`(bc.content->>'title' ILIKE %[1]s OR bc.content->>'text' ILIKE %[1]s)`, likePattern(filter.Query))
 	}
+	versionSQL := q.take()
 
-	rows, err := br.data.Master().QueryContext(br.Ctx, `
-        SELECT
-    b.id,
-	bft.version,
-    b.created_at,
-    b.updated_at,
-    bft.tag_id,
-    bft.feature_id,
-    bc.content
-	FROM banner b
-         JOIN banner_content bc ON b.id = bc.banner_id
-         JOIN banner_feature_tag bft ON b.id = bft.banner_id
-	WHERE bc.version = bft.version 
-	AND (bft.feature_id = $1 OR $1 IS NULL)
-    AND (tag_id = $2 OR $2 IS NULL)
-    LIMIT $3 OFFSET $4;
-    `, queryFeature, queryTag, queryLimit, queryOffset)
+	// conditions on a banner
+	q.and("b.deleted_at IS NULL")
+	if versionSQL != "TRUE" {
+		q.and(`EXISTS (SELECT 1 FROM banner_content bc WHERE bc.banner_id = b.id AND ` + versionSQL + `)`)
+	}
+	if filter.IsActive != nil {
+		q.where("b.is_active = %s", *filter.IsActive)
+	}
+	if filter.CreatedFrom != nil {
+		q.where("b.created_at >= %s", *filter.CreatedFrom)
+	}
+	if filter.CreatedTo != nil {
+		q.where("b.created_at < %s", *filter.CreatedTo)
+	}
+	if filter.UpdatedFrom != nil {
+		q.where("b.updated_at >= %s", *filter.UpdatedFrom)
+	}
+	if filter.UpdatedTo != nil {
+		q.where("b.updated_at < %s", *filter.UpdatedTo)
+	}
+	bannerSQL := q.take()
+
+	result := &models.BannerPage{}
+	err := br.data.Master().QueryRowContext(ctx,
+		`SELECT count(*) FROM banner b WHERE `+bannerSQL, q.args...).Scan(&result.Total)
 	if err != nil {
-		return nil, err
+		return nil, errs.WithMessage(err, "fail to count banners")
 	}
-	defer func(rows *sql.Rows) {
-		err = rows.Close()
-		if err != nil {
-			return
+
+	// conditions on the page
+	if c := page.Cursor; c != nil {
+		var key any = c.ID
+		switch sort.Field {
+		case models.SortByUpdatedAt:
+			key = c.UpdatedAt
+		case models.SortByFeatureID:
+			key = c.FeatureID
 		}
-	}(rows)
+		q.where("(sort_key, id) "+compare+" (%s::"+sortColumn.cast+", %s::int)", key, c.ID)
+	}
+	pageSQL := q.take()
 
-	defer func() {
-		_ = rows.Close()
-	}()
+	// one banner past the limit tells whether there is a next page
+	var queryLimit, queryOffset interface{}
+	if page.Limit != 0 {
+		queryLimit = page.Limit + 1
+	}
+	if page.Offset != 0 {
+		queryOffset = page.Offset
+	}
+
+	rows, err := br.data.Master().QueryContext(ctx, `
+	WITH banners AS (
+		SELECT b.id, `+sortColumn.expr+` AS sort_key
+		FROM banner b
+		WHERE `+bannerSQL+`
+	), page AS (
+		SELECT id, sort_key
+		FROM banners
+		WHERE `+pageSQL+`
+		ORDER BY sort_key `+direction+`, id `+direction+`
+		LIMIT `+q.arg(queryLimit)+` OFFSET `+q.arg(queryOffset)+`)
+	SELECT
+		p.sort_key,
+		b.id,
+		b.revision,
+		bft.version,
+		b.created_at,
+		b.updated_at,
+		b.starts_at,
+		b.ends_at,
+		bft.tag_id,
+		bft.feature_id,
+		bc.content,
+		bc.locales,
+		bc.variants
+	FROM page p
+	JOIN banner b ON b.id = p.id
+	JOIN banner_content bc ON b.id = bc.banner_id
+	JOIN banner_feature_tag bft ON b.id = bft.banner_id AND bft.version = bc.version
+	WHERE `+versionSQL+`
+	ORDER BY p.sort_key `+direction+`, p.id `+direction+`, bft.version, bft.tag_id`, q.args...)
+	if err != nil {
+		return nil, errs.WithMessage(err, "fail to get banners page")
+	}
+	defer func() { _ = rows.Close() }()
 
+	var pageSize int
+	var sortKey any
+	var hasMore bool
 	for rows.Next() {
 		var banner models.Banner
-		var contentJSON []byte
+		var contentJSON, localesJSON, variantsJSON []byte
 		var tag int
-		if err = rows.Scan(&banner.ID, &banner.Version, &banner.CreatedAt, &banner.UpdatedAt, &tag, &banner.FeatureID, &contentJSON); err != nil {
+		var rowSortKey any
+		if err = rows.Scan(&rowSortKey, &banner.ID, &banner.Revision, &banner.Version, &banner.CreatedAt,
+			&banner.UpdatedAt, &banner.StartsAt, &banner.EndsAt, &tag, &banner.FeatureID, &contentJSON, &localesJSON,
+			&variantsJSON); err != nil {
 			return nil, err
 		}
+
+		if last := len(result.Banners) - 1; last < 0 || result.Banners[last].ID != banner.ID {
+			if page.Limit != 0 && pageSize == page.Limit {
+				hasMore = true
+				break
+			}
+			pageSize++
+		}
+		sortKey = rowSortKey
+
 		if err = json.Unmarshal(contentJSON, &banner.Content); err != nil {
 			return nil, err
 		}
+		if err = json.Unmarshal(localesJSON, &banner.Locales); err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal(variantsJSON, &banner.Variants); err != nil {
+			return nil, err
+		}
 		banner.TagIDs = append(banner.TagIDs, tag)
-		banners = append(banners, &banner)
+		result.Banners = append(result.Banners, &banner)
 	}
 	if err = rows.Err(); err != nil {
 		return nil, err
 	}
 
-	return banners, nil
+	if hasMore {
+		last := result.Banners[len(result.Banners)-1]
+		result.NextCursor = &models.Cursor{Sort: sort.Field, Desc: sort.Desc, UpdatedAt: last.UpdatedAt, ID: last.ID}
+		if featureID, ok := sortKey.(int64); ok && sort.Field == models.SortByFeatureID {
+			result.NextCursor.FeatureID = int(featureID)
+		}
+	}
+
+	return result, nil
 }
 
 func (br *BannerRepo) CreateBanner(tx *sql.Tx, b *models.Banner) (int, error) {
@@ -135,11 +264,12 @@ func (br *BannerRepo) CreateBanner(tx *sql.Tx, b *models.Banner) (int, error) {
 	}
 
 	err := tx.QueryRowContext(ctx,
-		`INSERT INTO banner (created_at, updated_at, is_active, active_version, last_version)
-				VALUES ($1, $2, $3, $4, $5)
-				RETURNING id`, b.CreatedAt, b.UpdatedAt, b.IsActive, activeVersion, b.Version).Scan(&createdBannerID)
+		`INSERT INTO banner (created_at, updated_at, is_active, active_version, last_version, starts_at, ends_at)
+				VALUES ($1, $2, $3, $4, $5, $6, $7)
+				RETURNING id, revision`, b.CreatedAt, b.UpdatedAt, b.IsActive, activeVersion, b.Version, b.StartsAt, b.EndsAt).
+		Scan(&createdBannerID, &b.Revision)
 	if err != nil {
-		return 0, errs.New("fail to insert into banner table while exec Create")
+		return 0, scheduleError(errs.WithMessage(err, "fail to insert into banner table while exec Create"))
 	}
 
 	return createdBannerID, nil
@@ -153,10 +283,18 @@ func (br *BannerRepo) CreateContent(tx *sql.Tx, b *models.Banner) error {
 	if err != nil {
 		return errs.WithMessagef(err, "fail to marshal content to JSON, content: %v", b.Content)
 	}
+	localesJSON, err := marshalLocales(b.Locales)
+	if err != nil {
+		return err
+	}
+	variantsJSON, err := marshalVariants(b.Variants)
+	if err != nil {
+		return err
+	}
 
 	_, err = tx.ExecContext(ctx,
-		`INSERT INTO banner_content (banner_id, version, content, updated_at)
-				VALUES ($1, $2, $3, $4)`, b.ID, b.Version, contentJSON, b.UpdatedAt)
+		`INSERT INTO banner_content (banner_id, version, content, locales, variants, updated_at)
+				VALUES ($1, $2, $3, $4, $5, $6)`, b.ID, b.Version, contentJSON, localesJSON, variantsJSON, b.UpdatedAt)
 	if err != nil {
 		return errs.New("fail to insert into banner_content table while exec Create")
 	}
@@ -168,11 +306,11 @@ func (br *BannerRepo) CreateFeatureTags(tx *sql.Tx, b *models.Banner) error {
 	ctx, cancel := context.WithTimeout(br.Ctx, time.Second*5)
 	defer cancel()
 
-	err := br.AddNewTag(b)
+	err := br.AddNewTag(tx, b)
 	if err != nil {
 		return errs.WithMessagef(err, "fail to add new tags")
 	}
-	err = br.AddNewFeature(b)
+	err = br.AddNewFeature(tx, b)
 	if err != nil {
 		return errs.WithMessagef(err, "fail to add new feature")
 	}
@@ -189,7 +327,13 @@ func (br *BannerRepo) CreateFeatureTags(tx *sql.Tx, b *models.Banner) error {
 	return nil
 }
 
+// Create stores the first version of the banner. Its feature/tag pairs are locked and
+// checked for an overlapping active banner once more, so a concurrent create or restore
+// can't take them between the caller's check and the insert.
 func (br *BannerRepo) Create(b *models.Banner) error {
+	ctx, cancel := context.WithTimeout(br.Ctx, time.Second*5)
+	defer cancel()
+
 	b.CreatedAt = time.Now()
 	b.UpdatedAt = time.Now()
 
@@ -204,6 +348,18 @@ func (br *BannerRepo) Create(b *models.Banner) error {
 		}
 	}(tx)
 
+	if err = br.lockPairs(ctx, tx, b.FeatureID, b.TagIDs); err != nil {
+		return err
+	}
+	conflictID, err := br.overlap(ctx, tx, b)
+	if err == nil {
+		return errs.WithMessagef(ErrOverlap, "banner %d holds feature %d and one of tags %v",
+			conflictID, b.FeatureID, b.TagIDs)
+	}
+	if !errs.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
 	b.ID, err = br.CreateBanner(tx, b)
 	if err != nil {
 		return errs.WithMessagef(err, "fail to create banner with id %d", b.ID)
@@ -223,6 +379,7 @@ func (br *BannerRepo) Create(b *models.Banner) error {
 		logger.Errf("failed to commit transaction CreateBanner: %s", err)
 		return err
 	}
+	br.notifyChanged(b.ID)
 
 	return nil
 }
@@ -239,12 +396,15 @@ func (br *BannerRepo) UpdateBanner(tx *sql.Tx, b *models.Banner, lastVersion int
 		activeVersion = 0
 	}
 
-	_, err := tx.ExecContext(ctx,
+	err := tx.QueryRowContext(ctx,
 		`UPDATE banner
-				SET updated_at = $1, is_active = $2, active_version = $3, last_version = $4
-				WHERE id = $5`, b.UpdatedAt, b.IsActive, activeVersion, b.Version, b.ID)
+				SET updated_at = $1, is_active = $2, active_version = $3, last_version = $4, starts_at = $5, ends_at = $6,
+					revision = revision + 1
+				WHERE id = $7
+				RETURNING revision`, b.UpdatedAt, b.IsActive, activeVersion, b.Version, b.StartsAt, b.EndsAt, b.ID).
+		Scan(&b.Revision)
 	if err != nil {
-		return errs.New("fail to exec query: Update")
+		return scheduleError(errs.WithMessage(err, "fail to exec query: Update"))
 	}
 
 	return nil
@@ -258,10 +418,18 @@ func (br *BannerRepo) UpdateBannerContent(tx *sql.Tx, b *models.Banner) error {
 	if err != nil {
 		return errs.WithMessagef(err, "fail to marshal content to JSON, content: %v", b.Content)
 	}
+	localesJSON, err := marshalLocales(b.Locales)
+	if err != nil {
+		return err
+	}
+	variantsJSON, err := marshalVariants(b.Variants)
+	if err != nil {
+		return err
+	}
 
 	_, err = tx.ExecContext(ctx,
-		`INSERT INTO banner_content (banner_id, version, content, updated_at)
-				VALUES ($1, $2, $3, $4)`, b.ID, b.Version, contentJSON, b.UpdatedAt)
+		`INSERT INTO banner_content (banner_id, version, content, locales, variants, updated_at)
+				VALUES ($1, $2, $3, $4, $5, $6)`, b.ID, b.Version, contentJSON, localesJSON, variantsJSON, b.UpdatedAt)
 	if err != nil {
 		return errs.New("fail to exec query: UpdateBannerContent")
 	}
@@ -273,11 +441,11 @@ func (br *BannerRepo) UpdateFeatureTag(tx *sql.Tx, b *models.Banner) error {
 	ctx, cancel := context.WithTimeout(br.Ctx, time.Second*5)
 	defer cancel()
 
-	err := br.AddNewTag(b)
+	err := br.AddNewTag(tx, b)
 	if err != nil {
 		return errs.WithMessagef(err, "fail to add new tags")
 	}
-	err = br.AddNewFeature(b)
+	err = br.AddNewFeature(tx, b)
 	if err != nil {
 		return errs.WithMessagef(err, "fail to add new feature")
 	}
@@ -294,13 +462,16 @@ func (br *BannerRepo) UpdateFeatureTag(tx *sql.Tx, b *models.Banner) error {
 	return nil
 }
 
+// GetLastVersion locks the banner and returns its last version, b.Revision is set to the
+// current revision.
 func (br *BannerRepo) GetLastVersion(tx *sql.Tx, b *models.Banner) (error, int) {
 	ctx, cancel := context.WithTimeout(br.Ctx, time.Second*5)
 	defer cancel()
 
 	var lastVersion int
 	err := tx.QueryRowContext(ctx,
-		`SELECT last_version FROM banner WHERE id = $1`, b.ID).Scan(&lastVersion)
+		`SELECT last_version, revision FROM banner WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, b.ID).
+		Scan(&lastVersion, &b.Revision)
 	if err != nil {
 		return errs.WithMessagef(err, "failed to get last version for banner %d", b.ID), 0
 	}
@@ -312,16 +483,18 @@ func (br *BannerRepo) MergeUpdateVersion(tx *sql.Tx, b *models.Banner, lastVersi
 	ctx, cancel := context.WithTimeout(br.Ctx, time.Second*5)
 	defer cancel()
 
-	var contentJSON []byte
+	var contentJSON, localesJSON, variantsJSON []byte
 	var featureID int
+	var startsAt, endsAt *time.Time
 	err := tx.QueryRowContext(ctx,
-		`SELECT bc.content, bft.feature_id
+		`SELECT bc.content, bc.locales, bc.variants, bft.feature_id, b.starts_at, b.ends_at
 		FROM banner b 
 		JOIN banner_content bc on b.id = bc.banner_id
 		JOIN banner_feature_tag bft on bc.banner_id = bft.banner_id
 		WHERE b.id = $1 
 		AND bc.version = $2
-		AND bft.version = $3`, b.ID, lastVersion, lastVersion).Scan(&contentJSON, &featureID)
+		AND bft.version = $3`, b.ID, lastVersion, lastVersion).Scan(&contentJSON, &localesJSON,
+		&variantsJSON, &featureID, &startsAt, &endsAt)
 	if err != nil {
 		return nil, errs.WithMessagef(err, "fail to get old version for banner %d", b.ID)
 	}
@@ -332,6 +505,21 @@ func (br *BannerRepo) MergeUpdateVersion(tx *sql.Tx, b *models.Banner, lastVersi
 		return nil, errs.WithMessagef(err, "fail to unmarshal old content for banner %d", b.ID)
 	}
 
+	// locales are edited one at a time with SetLocale, a new version keeps them
+	b.Locales = nil
+	err = json.Unmarshal(localesJSON, &b.Locales)
+	if err != nil {
+		return nil, errs.WithMessagef(err, "fail to unmarshal old locales for banner %d", b.ID)
+	}
+
+	// variants are replaced as a whole, an update without them keeps the old ones
+	if b.Variants == nil {
+		err = json.Unmarshal(variantsJSON, &b.Variants)
+		if err != nil {
+			return nil, errs.WithMessagef(err, "fail to unmarshal old variants for banner %d", b.ID)
+		}
+	}
+
 	var oldTags []int
 	rows, err := tx.QueryContext(ctx,
 		`SELECT bft.tag_id
@@ -364,26 +552,32 @@ func (br *BannerRepo) MergeUpdateVersion(tx *sql.Tx, b *models.Banner, lastVersi
 		oldTags = append(oldTags, tagID)
 	}
 
-	if b.Content.Text == "" {
-		b.Content.Text = oldContent.Text
-	}
-	if b.Content.Title == "" {
-		b.Content.Title = oldContent.Title
-	}
-	if b.Content.URL == "" {
-		b.Content.URL = oldContent.URL
-	}
+	b.Content = oldContent.Merge(b.Content)
 	if b.FeatureID == 0 {
 		b.FeatureID = featureID
 	}
 	if b.TagIDs == nil {
 		b.TagIDs = oldTags
 	}
+	if b.StartsAt == nil && !b.ClearStartsAt {
+		b.StartsAt = startsAt
+	}
+	if b.EndsAt == nil && !b.ClearEndsAt {
+		b.EndsAt = endsAt
+	}
 
 	return b, nil
 }
 
-func (br *BannerRepo) Update(b *models.Banner) error {
+// Update stores the merged banner as a new version and sets b.Revision to the new revision.
+// A non-zero expectedRevision must match the revision of the banner, otherwise
+// ErrVersionMismatch is returned. ErrOverlap is returned when another active banner holds the
+// merged feature and one of the merged tags. The check is called with the merged banner before
+// it is written, its error aborts the update.
+func (br *BannerRepo) Update(b *models.Banner, expectedRevision int, check func(merged *models.Banner) error) error {
+	ctx, cancel := context.WithTimeout(br.Ctx, time.Second*5)
+	defer cancel()
+
 	b.UpdatedAt = time.Now()
 
 	tx, err := br.data.Master().Begin()
@@ -401,6 +595,10 @@ func (br *BannerRepo) Update(b *models.Banner) error {
 	if err != nil {
 		return errs.WithMessagef(err, "fail to get last version of banner %d", b.ID)
 	}
+	if expectedRevision != 0 && expectedRevision != b.Revision {
+		return errs.WithMessagef(ErrVersionMismatch, "banner %d is at revision %d, expected %d",
+			b.ID, b.Revision, expectedRevision)
+	}
 	b.Version = lastVersion + 1
 
 	b, err = br.MergeUpdateVersion(tx, b, lastVersion)
@@ -408,6 +606,24 @@ func (br *BannerRepo) Update(b *models.Banner) error {
 		return errs.WithMessagef(err, "fail to merge update banner %d", b.ID)
 	}
 
+	if err = br.lockPairs(ctx, tx, b.FeatureID, b.TagIDs); err != nil {
+		return err
+	}
+	conflictID, err := br.overlap(ctx, tx, b)
+	if err == nil {
+		return errs.WithMessagef(ErrOverlap, "banner %d holds feature %d and one of tags %v",
+			conflictID, b.FeatureID, b.TagIDs)
+	}
+	if !errs.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	if check != nil {
+		if err = check(b); err != nil {
+			return err
+		}
+	}
+
 	err = br.UpdateBanner(tx, b, lastVersion)
 	if err != nil {
 		return errs.WithMessagef(err, "fail to update banner with id %d", b.ID)
@@ -427,6 +643,7 @@ func (br *BannerRepo) Update(b *models.Banner) error {
 		logger.Errf("failed to commit transaction UpdateBanner: %s", err)
 		return err
 	}
+	br.notifyChanged(b.ID)
 
 	return nil
 }
@@ -435,24 +652,247 @@ func (br *BannerRepo) Delete(bannerID int) error {
 	ctx, cancel := context.WithTimeout(br.Ctx, time.Second*5)
 	defer cancel()
 
-	_, err := br.data.Master().ExecContext(ctx,
-		`DELETE 
+	res, err := br.data.Master().ExecContext(ctx,
+		`UPDATE banner
+		SET deleted_at = now(), revision = revision + 1
+		WHERE id = $1
+		AND deleted_at IS NULL`, bannerID)
+	if err != nil {
+		return errs.WithMessagef(err, "fail to exec query: DeleteBanner")
+	}
+
+	if deleted, err := res.RowsAffected(); err == nil && deleted == 0 {
+		return errs.WithMessagef(sql.ErrNoRows, "banner %d not found", bannerID)
+	}
+	br.notifyChanged(bannerID)
+
+	return nil
+}
+
+// DeleteBatch soft deletes up to batchSize banners whose active or last version uses the
+// feature and/or tag, a zero ID matches any. It returns the number of deleted banners and
+// every feature/tag pair those banners were ever served by.
+func (br *BannerRepo) DeleteBatch(featureID, tagID, batchSize int) (int, []models.FeatureTag, error) {
+	ctx, cancel := context.WithTimeout(br.Ctx, time.Second*5)
+	defer cancel()
+
+	rows, err := br.data.Master().QueryContext(ctx,
+		`WITH victims AS (
+			SELECT b.id
+			FROM banner b
+			WHERE b.deleted_at IS NULL
+			AND EXISTS (
+				SELECT 1
+				FROM banner_feature_tag bft
+				WHERE bft.banner_id = b.id
+				AND bft.version IN (b.active_version, b.last_version)
+				AND ($1 = 0 OR bft.feature_id = $1)
+				AND ($2 = 0 OR bft.tag_id = $2))
+			ORDER BY b.id
+			LIMIT $3
+			FOR UPDATE
+		), deleted AS (
+			UPDATE banner
+			SET deleted_at = now(), revision = banner.revision + 1
+			FROM victims
+			WHERE banner.id = victims.id
+			RETURNING banner.id
+		)
+		SELECT DISTINCT d.id, bft.feature_id, bft.tag_id
+		FROM deleted d
+		JOIN banner_feature_tag bft ON bft.banner_id = d.id`, featureID, tagID, batchSize)
+	if err != nil {
+		return 0, nil, errs.WithMessagef(err, "fail to delete banners with feature %d and tag %d", featureID, tagID)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var deleted []int
+	var pairs []models.FeatureTag
+	for rows.Next() {
+		var bannerID int
+		var pair models.FeatureTag
+		if err = rows.Scan(&bannerID, &pair.FeatureID, &pair.TagID); err != nil {
+			return 0, nil, errs.WithMessagef(err, "fail to scan deleted banner")
+		}
+		if !slices.Contains(deleted, bannerID) {
+			deleted = append(deleted, bannerID)
+		}
+		pairs = append(pairs, pair)
+	}
+	if err = rows.Err(); err != nil {
+		return 0, nil, err
+	}
+	br.notifyChanged(deleted...)
+
+	return len(deleted), pairs, nil
+}
+
+// GetTrash returns soft deleted banners with the version that was served before the
+// deletion, or the last version for inactive banners. A zero bannerID returns the whole trash.
+func (br *BannerRepo) GetTrash(bannerID int) ([]*models.Banner, error) {
+	ctx, cancel := context.WithTimeout(br.Ctx, time.Second*5)
+	defer cancel()
+
+	rows, err := br.data.Master().QueryContext(ctx,
+		`SELECT b.id, b.created_at, b.updated_at, b.deleted_at, b.starts_at, b.ends_at, b.is_active,
+			bc.version, bc.content, bft.feature_id, bft.tag_id
+		FROM banner b
+		JOIN banner_content bc ON b.id = bc.banner_id
+		JOIN banner_feature_tag bft ON bc.banner_id = bft.banner_id AND bc.version = bft.version
+		WHERE b.deleted_at IS NOT NULL
+		AND ($1 = 0 OR b.id = $1)
+		AND bc.version = coalesce(nullif(b.active_version, 0), b.last_version)
+		ORDER BY b.deleted_at DESC, b.id, bft.tag_id`, bannerID)
+	if err != nil {
+		return nil, errs.WithMessagef(err, "fail to get deleted banners")
+	}
+	defer func() { _ = rows.Close() }()
+
+	var banners []*models.Banner
+	for rows.Next() {
+		var banner models.Banner
+		var contentJSON []byte
+		var tagID int
+		err = rows.Scan(&banner.ID, &banner.CreatedAt, &banner.UpdatedAt, &banner.DeletedAt, &banner.StartsAt,
+			&banner.EndsAt, &banner.IsActive, &banner.Version, &contentJSON, &banner.FeatureID, &tagID)
+		if err != nil {
+			return nil, errs.WithMessagef(err, "fail to scan deleted banner")
+		}
+
+		if n := len(banners); n > 0 && banners[n-1].ID == banner.ID {
+			banners[n-1].TagIDs = append(banners[n-1].TagIDs, tagID)
+			continue
+		}
+
+		if err = json.Unmarshal(contentJSON, &banner.Content); err != nil {
+			return nil, errs.WithMessagef(err, "fail to unmarshal content of deleted banner %d", banner.ID)
+		}
+		banner.TagIDs = []int{tagID}
+		banners = append(banners, &banner)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return banners, nil
+}
+
+// Restore brings a soft deleted banner back. An active banner is restored only if no other
+// active banner holds its feature and tags in an overlapping window, the check and the
+// restore run with the pairs locked, so a concurrent create can't take them in between.
+func (br *BannerRepo) Restore(bannerID int) error {
+	ctx, cancel := context.WithTimeout(br.Ctx, time.Second*5)
+	defer cancel()
+
+	tx, err := br.data.Master().BeginTx(ctx, nil)
+	if err != nil {
+		return errs.WithMessagef(err, "can't begin transaction to restore banner %d", bannerID)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	deleted := &models.Banner{ID: bannerID}
+	err = tx.QueryRowContext(ctx,
+		`SELECT is_active, starts_at, ends_at
 		FROM banner
+		WHERE id = $1
+		AND deleted_at IS NOT NULL
+		FOR UPDATE`, bannerID).Scan(&deleted.IsActive, &deleted.StartsAt, &deleted.EndsAt)
+	if err != nil {
+		return errs.WithMessagef(err, "banner %d not found in trash", bannerID)
+	}
+
+	if deleted.IsActive {
+		rows, err := tx.QueryContext(ctx,
+			`SELECT bft.feature_id, bft.tag_id
+			FROM banner b
+			JOIN banner_feature_tag bft ON bft.banner_id = b.id AND bft.version = b.active_version
+			WHERE b.id = $1
+			ORDER BY bft.tag_id`, bannerID)
+		if err != nil {
+			return errs.WithMessagef(err, "fail to get feature tags of banner %d", bannerID)
+		}
+		for rows.Next() {
+			var tagID int
+			if err = rows.Scan(&deleted.FeatureID, &tagID); err != nil {
+				_ = rows.Close()
+				return errs.WithMessagef(err, "fail to scan feature tag of banner %d", bannerID)
+			}
+			deleted.TagIDs = append(deleted.TagIDs, tagID)
+		}
+		_ = rows.Close()
+		if err = rows.Err(); err != nil {
+			return err
+		}
+
+		if err = br.lockPairs(ctx, tx, deleted.FeatureID, deleted.TagIDs); err != nil {
+			return err
+		}
+		conflictID, err := br.overlap(ctx, tx, deleted)
+		if err == nil {
+			return errs.WithMessagef(ErrOverlap, "banner %d with tag: %d and feature: %d is active, "+
+				"restore is not possible", conflictID, deleted.TagIDs, deleted.FeatureID)
+		}
+		if !errs.Is(err, sql.ErrNoRows) {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE banner
+		SET deleted_at = NULL, revision = revision + 1
 		WHERE id = $1`, bannerID)
 	if err != nil {
-		return errs.WithMessagef(err, "fail to exec query: DeleteBanner")
+		return errs.WithMessagef(err, "fail to restore banner %d", bannerID)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return errs.WithMessagef(err, "fail to commit restore of banner %d", bannerID)
 	}
+	br.notifyChanged(bannerID)
 
 	return nil
 }
 
+// PurgeDeleted permanently removes banners deleted before the given time together
+// with their content history.
+func (br *BannerRepo) PurgeDeleted(deletedBefore time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(br.Ctx, time.Second*30)
+	defer cancel()
+
+	res, err := br.data.Master().ExecContext(ctx,
+		`DELETE
+		FROM banner
+		WHERE deleted_at < $1`, deletedBefore)
+	if err != nil {
+		return 0, errs.WithMessagef(err, "fail to purge banners deleted before %s", deletedBefore)
+	}
+
+	purged, err := res.RowsAffected()
+	if err != nil {
+		return 0, errs.WithMessagef(err, "fail to get purged banners count")
+	}
+
+	return int(purged), nil
+}
+
 func (br *BannerRepo) CheckTagFeatureOverlap(b *models.Banner) (int, error) {
 	ctx, cancel := context.WithTimeout(br.Ctx, time.Second*5)
 	defer cancel()
 
+	return br.overlap(ctx, br.data.Master(), b)
+}
+
+// queryRower runs a query on the database or in a transaction.
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// overlap returns an active banner other than b that holds its feature and one of its tags
+// in an overlapping window, sql.ErrNoRows when there is none.
+func (br *BannerRepo) overlap(ctx context.Context, q queryRower, b *models.Banner) (int, error) {
 	var bannerID int
 
-	err := br.data.Master().QueryRowContext(ctx,
+	err := q.QueryRowContext(ctx,
 		`
 	SELECT b.id
 	FROM banner b
@@ -460,7 +900,14 @@ func (br *BannerRepo) CheckTagFeatureOverlap(b *models.Banner) (int, error) {
 	WHERE b.is_active = true
 	AND b.active_version = bft.version
 	AND bft.tag_id = ANY($1)
-	AND bft.feature_id = $2`, pq.Array(b.TagIDs), b.FeatureID).Scan(&bannerID)
+	AND bft.feature_id = $2
+	AND b.deleted_at IS NULL
+	AND b.id <> $5
+	AND tstzrange(b.starts_at, b.ends_at) && tstzrange(
+		CASE WHEN $6 THEN NULL ELSE coalesce($3, (SELECT starts_at FROM banner WHERE id = $5)) END,
+		CASE WHEN $7 THEN NULL ELSE coalesce($4, (SELECT ends_at FROM banner WHERE id = $5)) END)
+	LIMIT 1`, pq.Array(b.TagIDs), b.FeatureID, b.StartsAt, b.EndsAt, b.ID, b.ClearStartsAt, b.ClearEndsAt).
+		Scan(&bannerID)
 	if err != nil {
 		return 0, errs.WithMessagef(err, "active banner with this combination of tagIDs and featureID is not found")
 	}
@@ -468,6 +915,21 @@ func (br *BannerRepo) CheckTagFeatureOverlap(b *models.Banner) (int, error) {
 	return bannerID, nil
 }
 
+// lockPairs serializes the writes that check and take feature/tag pairs until the
+// transaction ends. The pairs are locked in tag order, so two writers can't deadlock.
+func (br *BannerRepo) lockPairs(ctx context.Context, tx *sql.Tx, featureID int, tagIDs []int) error {
+	tags := slices.Clone(tagIDs)
+	slices.Sort(tags)
+
+	for _, tagID := range slices.Compact(tags) {
+		if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1, $2)`, featureID, tagID); err != nil {
+			return errs.WithMessagef(err, "fail to lock feature %d and tag %d", featureID, tagID)
+		}
+	}
+
+	return nil
+}
+
 func (br *BannerRepo) GetBannerActiveVersions() (map[int]int, error) {
 	ctx, cancel := context.WithTimeout(br.Ctx, time.Second*5)
 	defer cancel()
@@ -506,67 +968,462 @@ func (br *BannerRepo) GetBannerActiveVersions() (map[int]int, error) {
 	return activeVersions, nil
 }
 
-func (br *BannerRepo) SetVersionActive(bannerID, version int) error {
+func (br *BannerRepo) GetVersions(bannerID int) ([]*models.Banner, error) {
 	ctx, cancel := context.WithTimeout(br.Ctx, time.Second*5)
 	defer cancel()
 
-	_, err := br.data.Master().ExecContext(ctx,
-		`UPDATE banner
-		SET is_active = true, active_version = $1
-		WHERE id = $2`, version, bannerID)
+	rows, err := br.data.Master().QueryContext(ctx,
+		`SELECT b.id, b.revision, b.created_at, b.starts_at, b.ends_at, bc.version, bc.updated_at, bc.content,
+			bc.locales, bc.variants, b.is_active AND b.active_version = bc.version,
+			bft.feature_id, bft.tag_id
+		FROM banner b
+		JOIN banner_content bc ON b.id = bc.banner_id
+		JOIN banner_feature_tag bft ON bc.banner_id = bft.banner_id AND bc.version = bft.version
+		WHERE b.id = $1
+		AND b.deleted_at IS NULL
+		ORDER BY bc.version, bft.tag_id`, bannerID)
 	if err != nil {
-		return errs.WithMessagef(err, "fail to set active version for banner %d", bannerID)
+		return nil, errs.WithMessagef(err, "fail to get versions for banner %d", bannerID)
 	}
+	defer func() { _ = rows.Close() }()
 
-	return nil
+	var versions []*models.Banner
+	for rows.Next() {
+		var banner models.Banner
+		var contentJSON, localesJSON, variantsJSON []byte
+		var tagID int
+		err = rows.Scan(&banner.ID, &banner.Revision, &banner.CreatedAt, &banner.StartsAt, &banner.EndsAt,
+			&banner.Version, &banner.UpdatedAt, &contentJSON, &localesJSON, &variantsJSON, &banner.IsActive,
+			&banner.FeatureID, &tagID)
+		if err != nil {
+			return nil, errs.WithMessagef(err, "fail to scan version of banner %d", bannerID)
+		}
+
+		if n := len(versions); n > 0 && versions[n-1].Version == banner.Version {
+			versions[n-1].TagIDs = append(versions[n-1].TagIDs, tagID)
+			continue
+		}
+
+		if err = json.Unmarshal(contentJSON, &banner.Content); err != nil {
+			return nil, errs.WithMessagef(err, "fail to unmarshal content of banner %d version %d", bannerID, banner.Version)
+		}
+		if err = json.Unmarshal(localesJSON, &banner.Locales); err != nil {
+			return nil, errs.WithMessagef(err, "fail to unmarshal locales of banner %d version %d", bannerID, banner.Version)
+		}
+		if err = json.Unmarshal(variantsJSON, &banner.Variants); err != nil {
+			return nil, errs.WithMessagef(err, "fail to unmarshal variants of banner %d version %d", bannerID, banner.Version)
+		}
+		banner.TagIDs = []int{tagID}
+		versions = append(versions, &banner)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(versions) == 0 {
+		return nil, errs.WithMessagef(sql.ErrNoRows, "no versions found for banner %d", bannerID)
+	}
+
+	return versions, nil
 }
 
-func (br *BannerRepo) AddNewTag(banner *models.Banner) error {
+// GetVersion returns the given version of the banner, a zero version means the last one.
+func (br *BannerRepo) GetVersion(bannerID, version int) (*models.Banner, error) {
 	ctx, cancel := context.WithTimeout(br.Ctx, time.Second*5)
 	defer cancel()
 
-	for _, tagID := range banner.TagIDs {
-		var count int
-		err := br.data.Master().QueryRowContext(ctx,
-			`SELECT COUNT(*) 
-			FROM tag
-			WHERE id = $1`, tagID).Scan(&count)
+	rows, err := br.data.Master().QueryContext(ctx,
+		`SELECT b.id, b.revision, b.created_at, b.starts_at, b.ends_at, bc.version, bc.updated_at, bc.content,
+			bc.locales, bc.variants, b.is_active AND b.active_version = bc.version,
+			bft.feature_id, bft.tag_id
+		FROM banner b
+		JOIN banner_content bc ON b.id = bc.banner_id
+		JOIN banner_feature_tag bft ON bc.banner_id = bft.banner_id AND bc.version = bft.version
+		WHERE b.id = $1
+		AND bc.version = CASE WHEN $2 = 0 THEN b.last_version ELSE $2 END
+		AND b.deleted_at IS NULL
+		ORDER BY bft.tag_id`, bannerID, version)
+	if err != nil {
+		return nil, errs.WithMessagef(err, "fail to get version %d for banner %d", version, bannerID)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var banner *models.Banner
+	for rows.Next() {
+		var (
+			row                                    models.Banner
+			contentJSON, localesJSON, variantsJSON []byte
+			tagID                                  int
+		)
+		err = rows.Scan(&row.ID, &row.Revision, &row.CreatedAt, &row.StartsAt, &row.EndsAt, &row.Version,
+			&row.UpdatedAt, &contentJSON, &localesJSON, &variantsJSON, &row.IsActive, &row.FeatureID, &tagID)
 		if err != nil {
-			return errs.WithMessagef(err, "failed to check tag existence for ID %d", tagID)
-		}
-		if count == 0 {
-			_, err = br.data.Master().ExecContext(ctx,
-				`INSERT INTO tag (id, name) 
-				VALUES ($1, $2)`, tagID, strconv.Itoa(tagID))
-			if err != nil {
-				return errs.WithMessagef(err, "failed to add new tag with ID %d", tagID)
+			return nil, errs.WithMessagef(err, "fail to scan version %d of banner %d", version, bannerID)
+		}
+
+		if banner == nil {
+			if err = json.Unmarshal(contentJSON, &row.Content); err != nil {
+				return nil, errs.WithMessagef(err, "fail to unmarshal content of banner %d version %d", bannerID, version)
 			}
+			if err = json.Unmarshal(localesJSON, &row.Locales); err != nil {
+				return nil, errs.WithMessagef(err, "fail to unmarshal locales of banner %d version %d", bannerID, version)
+			}
+			if err = json.Unmarshal(variantsJSON, &row.Variants); err != nil {
+				return nil, errs.WithMessagef(err, "fail to unmarshal variants of banner %d version %d", bannerID, version)
+			}
+			banner = &row
+		}
+		banner.TagIDs = append(banner.TagIDs, tagID)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if banner == nil {
+		return nil, errs.WithMessagef(sql.ErrNoRows, "version %d not found for banner %d", version, bannerID)
+	}
+
+	return banner, nil
+}
+
+// GetFeatureTags returns every feature/tag pair stored in any version of the banner,
+// including deleted banners.
+func (br *BannerRepo) GetFeatureTags(bannerID int) ([]models.FeatureTag, error) {
+	ctx, cancel := context.WithTimeout(br.Ctx, time.Second*5)
+	defer cancel()
+
+	rows, err := br.data.Master().QueryContext(ctx,
+		`SELECT DISTINCT feature_id, tag_id
+		FROM banner_feature_tag
+		WHERE banner_id = $1`, bannerID)
+	if err != nil {
+		return nil, errs.WithMessagef(err, "fail to get feature tags for banner %d", bannerID)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var pairs []models.FeatureTag
+	for rows.Next() {
+		var pair models.FeatureTag
+		if err = rows.Scan(&pair.FeatureID, &pair.TagID); err != nil {
+			return nil, errs.WithMessagef(err, "fail to scan feature tag of banner %d", bannerID)
 		}
+		pairs = append(pairs, pair)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
 	}
 
-	return nil
+	return pairs, nil
+}
+
+// GetActiveContents returns the content and the locales currently shown to users for every
+// feature/tag pair, each banner holds a single pair. It reads the whole table, hence the timeout is up to the caller.
+func (br *BannerRepo) GetActiveContents(timeout time.Duration) ([]*models.Banner, error) {
+	ctx, cancel := context.WithTimeout(br.Ctx, timeout)
+	defer cancel()
+
+	rows, err := br.data.Master().QueryContext(ctx,
+		`SELECT b.id, bc.version, bft.feature_id, bft.tag_id, bc.content, bc.locales, bc.variants, b.starts_at,
+			b.ends_at
+		FROM banner_content bc
+		JOIN banner b ON bc.banner_id = b.id
+		JOIN banner_feature_tag bft ON b.id = bft.banner_id
+		WHERE b.is_active = true
+		AND b.active_version = bc.version
+		AND bft.version = bc.version
+		AND b.deleted_at IS NULL
+		AND (b.starts_at IS NULL OR b.starts_at <= now())
+		AND (b.ends_at IS NULL OR b.ends_at > now())`)
+	if err != nil {
+		return nil, errs.WithMessage(err, "fail to get active banner contents")
+	}
+	defer func() { _ = rows.Close() }()
+
+	var banners []*models.Banner
+	for rows.Next() {
+		var contentJSON, localesJSON, variantsJSON []byte
+		var tagID int
+		banner := &models.Banner{IsActive: true}
+		if err = rows.Scan(&banner.ID, &banner.Version, &banner.FeatureID, &tagID, &contentJSON, &localesJSON,
+			&variantsJSON, &banner.StartsAt, &banner.EndsAt); err != nil {
+			return nil, errs.WithMessage(err, "fail to scan active banner content")
+		}
+
+		if err = json.Unmarshal(contentJSON, &banner.Content); err != nil {
+			return nil, errs.WithMessagef(err, "failed to unmarshal content with bannerID %d", banner.ID)
+		}
+		if err = json.Unmarshal(localesJSON, &banner.Locales); err != nil {
+			return nil, errs.WithMessagef(err, "failed to unmarshal locales with bannerID %d", banner.ID)
+		}
+		if err = json.Unmarshal(variantsJSON, &banner.Variants); err != nil {
+			return nil, errs.WithMessagef(err, "failed to unmarshal variants with bannerID %d", banner.ID)
+		}
+
+		banner.TagIDs = []int{tagID}
+		banners = append(banners, banner)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return banners, nil
+}
+
+// PruneVersions deletes stored versions that are neither among the keepLast newest
+// versions nor newer than olderThan. A zero keepLast or olderThan disables that rule,
+// a zero bannerID applies the policy to every banner. The active and the last version
+// are never pruned, the latter is needed to merge the next update.
+func (br *BannerRepo) PruneVersions(bannerID, keepLast int, olderThan time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(br.Ctx, time.Second*30)
+	defer cancel()
+
+	var cutoff interface{}
+	if !olderThan.IsZero() {
+		cutoff = olderThan
+	}
+
+	res, err := br.data.Master().ExecContext(ctx,
+		`WITH ranked AS (
+			SELECT bc.banner_id, bc.version, bc.updated_at,
+				row_number() OVER (PARTITION BY bc.banner_id ORDER BY bc.version DESC) AS rn
+			FROM banner_content bc
+			WHERE ($1 = 0 OR bc.banner_id = $1)
+		), prunable AS (
+			SELECT r.banner_id, r.version
+			FROM ranked r
+			JOIN banner b ON b.id = r.banner_id
+			WHERE r.version <> b.active_version
+			AND r.version <> b.last_version
+			AND ($2 = 0 OR r.rn > $2)
+			AND ($3::timestamptz IS NULL OR r.updated_at < $3)
+		), pruned_tags AS (
+			DELETE FROM banner_feature_tag bft
+			USING prunable p
+			WHERE bft.banner_id = p.banner_id
+			AND bft.version = p.version
+		)
+		DELETE FROM banner_content bc
+		USING prunable p
+		WHERE bc.banner_id = p.banner_id
+		AND bc.version = p.version`, bannerID, keepLast, cutoff)
+	if err != nil {
+		return 0, errs.WithMessagef(err, "fail to prune versions for banner %d", bannerID)
+	}
+
+	pruned, err := res.RowsAffected()
+	if err != nil {
+		return 0, errs.WithMessagef(err, "fail to get pruned versions count for banner %d", bannerID)
+	}
+
+	return int(pruned), nil
 }
 
-func (br *BannerRepo) AddNewFeature(banner *models.Banner) error {
+// SetVersionActive activates the version and returns the new revision of the banner, a
+// version that is not stored, e.g. a pruned one, is not found. A non-zero expectedRevision
+// must match the revision of the banner, otherwise ErrVersionMismatch is returned.
+func (br *BannerRepo) SetVersionActive(bannerID, version, expectedRevision int) (int, error) {
 	ctx, cancel := context.WithTimeout(br.Ctx, time.Second*5)
 	defer cancel()
 
-	var count int
+	var revision int
 	err := br.data.Master().QueryRowContext(ctx,
-		`SELECT COUNT(*) 
-		FROM feature
-		WHERE id = $1`, banner.FeatureID).Scan(&count)
+		`UPDATE banner
+		SET is_active = true, active_version = $1, revision = revision + 1, updated_at = now()
+		WHERE id = $2
+		AND deleted_at IS NULL
+		AND ($3 = 0 OR revision = $3)
+		AND EXISTS (SELECT 1 FROM banner_content WHERE banner_id = $2 AND version = $1)
+		RETURNING revision`, version, bannerID, expectedRevision).Scan(&revision)
+	if errs.Is(err, sql.ErrNoRows) {
+		return 0, br.writeMissed(ctx, bannerID, version, expectedRevision)
+	}
 	if err != nil {
-		return errs.WithMessagef(err, "failed to check feature existence for ID %d", banner.FeatureID)
+		return 0, errs.WithMessagef(err, "fail to set active version for banner %d", bannerID)
 	}
-	if count == 0 {
-		_, err = br.data.Master().ExecContext(ctx,
-			`INSERT INTO feature (id, name) 
-			VALUES ($1, $2)`, banner.FeatureID, strconv.Itoa(banner.FeatureID))
-		if err != nil {
-			return errs.WithMessagef(err, "failed to add new feature with ID %d", banner.FeatureID)
+	br.notifyChanged(bannerID)
+
+	return revision, nil
+}
+
+// SetLocaleContent replaces the content of one locale in place and returns the new revision
+// of the banner, nil content removes the locale. The version served to users is edited: the
+// active one, the last one when the banner is not active. A non-zero expectedRevision must
+// match the revision of the banner, otherwise ErrVersionMismatch is returned. The check is
+// called with the feature of the edited version before it is written, its error aborts the
+// write.
+func (br *BannerRepo) SetLocaleContent(bannerID int, locale string, content models.Content, expectedRevision int,
+	check func(featureID int) error) (int, error) {
+	ctx, cancel := context.WithTimeout(br.Ctx, time.Second*5)
+	defer cancel()
+
+	contentArg, err := localeArg(content)
+	if err != nil {
+		return 0, errs.WithMessagef(err, "fail to marshal %s content of banner %d", locale, bannerID)
+	}
+
+	tx, err := br.data.Master().BeginTx(ctx, nil)
+	if err != nil {
+		return 0, errs.WithMessagef(err, "can't begin transaction to set %s content of banner %d", locale, bannerID)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var revision, version, featureID int
+	err = tx.QueryRowContext(ctx,
+		`SELECT b.revision, bft.version, bft.feature_id
+		FROM banner b
+		JOIN banner_feature_tag bft ON bft.banner_id = b.id
+			AND bft.version = coalesce(nullif(b.active_version, 0), b.last_version)
+		WHERE b.id = $1
+		AND b.deleted_at IS NULL
+		LIMIT 1
+		FOR UPDATE OF b`, bannerID).Scan(&revision, &version, &featureID)
+	if err != nil {
+		return 0, errs.WithMessagef(err, "banner %d not found", bannerID)
+	}
+	if expectedRevision != 0 && expectedRevision != revision {
+		return 0, errs.WithMessagef(ErrVersionMismatch, "banner %d is at revision %d, expected %d",
+			bannerID, revision, expectedRevision)
+	}
+	if check != nil {
+		if err = check(featureID); err != nil {
+			return 0, err
 		}
 	}
 
+	_, err = tx.ExecContext(ctx,
+		`UPDATE banner_content
+		SET locales = CASE WHEN $4::jsonb IS NULL THEN locales - $3::text
+				ELSE jsonb_set(locales, ARRAY[$3::text], $4::jsonb) END,
+			updated_at = now()
+		WHERE banner_id = $1
+		AND version = $2`, bannerID, version, locale, contentArg)
+	if err != nil {
+		return 0, errs.WithMessagef(err, "fail to set %s content of banner %d", locale, bannerID)
+	}
+
+	err = tx.QueryRowContext(ctx,
+		`UPDATE banner
+		SET revision = revision + 1, updated_at = now()
+		WHERE id = $1
+		RETURNING revision`, bannerID).Scan(&revision)
+	if err != nil {
+		return 0, errs.WithMessagef(err, "fail to bump revision of banner %d", bannerID)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, errs.WithMessagef(err, "fail to commit %s content of banner %d", locale, bannerID)
+	}
+	br.notifyChanged(bannerID)
+
+	return revision, nil
+}
+
+// localeArg returns the query argument of the locale content, nil content removes the locale
+// and is passed as SQL NULL. It must be an untyped nil: lib/pq sends a nil []byte as an empty
+// string, which is not valid jsonb.
+func localeArg(content models.Content) (any, error) {
+	if content == nil {
+		return nil, nil
+	}
+
+	contentJSON, err := json.Marshal(content)
+	if err != nil {
+		return nil, err
+	}
+
+	return contentJSON, nil
+}
+
+// writeMissed tells why a conditional write of the banner version matched no row: the
+// banner or the version is not found, or the banner is at another revision.
+func (br *BannerRepo) writeMissed(ctx context.Context, bannerID, version, expectedRevision int) error {
+	var revision int
+	var stored bool
+	err := br.data.Master().QueryRowContext(ctx,
+		`SELECT revision, EXISTS (SELECT 1 FROM banner_content WHERE banner_id = $1 AND version = $2)
+		FROM banner
+		WHERE id = $1
+		AND deleted_at IS NULL`, bannerID, version).Scan(&revision, &stored)
+	if err != nil {
+		return errs.WithMessagef(err, "banner %d not found", bannerID)
+	}
+	// a pruned version can't be served, activating it would take the banner down
+	if !stored {
+		return errs.WithMessagef(sql.ErrNoRows, "version %d not found for banner %d", version, bannerID)
+	}
+
+	return errs.WithMessagef(ErrVersionMismatch, "banner %d is at revision %d, expected %d",
+		bannerID, revision, expectedRevision)
+}
+
+// scheduleError reports a write rejected by banner_schedule_check as ErrInvalidSchedule.
+func scheduleError(err error) error {
+	var pqErr *pq.Error
+	if errs.As(err, &pqErr) && pqErr.Code == checkViolation && pqErr.Constraint == "banner_schedule_check" {
+		return errs.WithMessage(ErrInvalidSchedule, pqErr.Message)
+	}
+
+	return err
+}
+
+// marshalVariants stores a banner without an A/B test as an empty array.
+func marshalVariants(variants []models.Variant) ([]byte, error) {
+	if variants == nil {
+		return []byte(`[]`), nil
+	}
+
+	variantsJSON, err := json.Marshal(variants)
+	if err != nil {
+		return nil, errs.WithMessagef(err, "fail to marshal variants to JSON, variants: %v", variants)
+	}
+
+	return variantsJSON, nil
+}
+
+// marshalLocales stores a banner without localized content as an empty object.
+func marshalLocales(locales map[string]models.Content) ([]byte, error) {
+	if locales == nil {
+		return []byte(`{}`), nil
+	}
+
+	localesJSON, err := json.Marshal(locales)
+	if err != nil {
+		return nil, errs.WithMessagef(err, "fail to marshal locales to JSON, locales: %v", locales)
+	}
+
+	return localesJSON, nil
+}
+
+// AddNewTag adds the tags of the banner missing in the catalog within the transaction of
+// the banner write. They get an empty name, an admin names them through the catalog API.
+func (br *BannerRepo) AddNewTag(tx *sql.Tx, banner *models.Banner) error {
+	ctx, cancel := context.WithTimeout(br.Ctx, time.Second*5)
+	defer cancel()
+
+	_, err := tx.ExecContext(ctx,
+		`INSERT INTO tag (id, name)
+		SELECT unnest($1::int[]), ''
+		ON CONFLICT (id) DO NOTHING`, pq.Array(banner.TagIDs))
+	if err != nil {
+		return errs.WithMessagef(err, "failed to add new tags with IDs %v", banner.TagIDs)
+	}
+
+	return nil
+}
+
+// AddNewFeature adds the feature of the banner when it is missing in the catalog, like
+// AddNewTag does for tags.
+func (br *BannerRepo) AddNewFeature(tx *sql.Tx, banner *models.Banner) error {
+	ctx, cancel := context.WithTimeout(br.Ctx, time.Second*5)
+	defer cancel()
+
+	_, err := tx.ExecContext(ctx,
+		`INSERT INTO feature (id, name)
+		VALUES ($1, '')
+		ON CONFLICT (id) DO NOTHING`, banner.FeatureID)
+	if err != nil {
+		return errs.WithMessagef(err, "failed to add new feature with ID %d", banner.FeatureID)
+	}
+
 	return nil
 }
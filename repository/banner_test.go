@@ -0,0 +1,37 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/mashmorsik/banners-service/pkg/models"
+)
+
+func TestLocaleArg(t *testing.T) {
+	tests := []struct {
+		name    string
+		content models.Content
+		want    any
+	}{
+		{name: "nil_content_is_sql_null", content: nil, want: nil},
+		{name: "content_is_json", content: models.Content{"title": "қазақ"}, want: `{"title":"қазақ"}`},
+		{name: "empty_content_is_an_empty_object", content: models.Content{}, want: `{}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := localeArg(tt.content)
+			if err != nil {
+				t.Fatalf("localeArg() error = %v", err)
+			}
+			if tt.want == nil {
+				// a typed nil such as []byte(nil) is sent by lib/pq as an empty string
+				if got != nil {
+					t.Errorf("localeArg() got = %#v, want untyped nil", got)
+				}
+				return
+			}
+			if data, ok := got.([]byte); !ok || string(data) != tt.want {
+				t.Errorf("localeArg() got = %#v, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/mashmorsik/banners-service/pkg/models"
+	errs "github.com/pkg/errors"
+)
+
+const (
+	foreignKeyViolation = "23503"
+	uniqueViolation     = "23505"
+	checkViolation      = "23514"
+)
+
+const catalogColumns = `id, name, description, archived, created_at, updated_at`
+
+// catalogTable returns the table of the catalog, the kind is never used in a query otherwise.
+func catalogTable(kind models.CatalogKind) (string, error) {
+	switch kind {
+	case models.CatalogTags, models.CatalogFeatures:
+		return string(kind), nil
+	default:
+		return "", errs.Errorf("unknown catalog: %s", kind)
+	}
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanCatalogEntry(row rowScanner) (*models.CatalogEntry, error) {
+	var entry models.CatalogEntry
+	err := row.Scan(&entry.ID, &entry.Name, &entry.Description, &entry.Archived, &entry.CreatedAt, &entry.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &entry, nil
+}
+
+// ListCatalog returns the entries ordered by ID, only the given ones when ids is not nil.
+func (br *BannerRepo) ListCatalog(kind models.CatalogKind, ids []int, includeArchived bool) ([]*models.CatalogEntry, error) {
+	ctx, cancel := context.WithTimeout(br.Ctx, time.Second*5)
+	defer cancel()
+
+	table, err := catalogTable(kind)
+	if err != nil {
+		return nil, err
+	}
+
+	q := &queryBuilder{}
+	if ids != nil {
+		q.where("id = ANY(%s::int[])", pq.Array(ids))
+	}
+	if !includeArchived {
+		q.and("NOT archived")
+	}
+
+	rows, err := br.data.Master().QueryContext(ctx,
+		`SELECT `+catalogColumns+`
+		FROM `+table+`
+		WHERE `+q.take()+`
+		ORDER BY id`, q.args...)
+	if err != nil {
+		return nil, errs.WithMessagef(err, "fail to list %s catalog", kind)
+	}
+	defer func() { _ = rows.Close() }()
+
+	entries := make([]*models.CatalogEntry, 0)
+	for rows.Next() {
+		entry, err := scanCatalogEntry(rows)
+		if err != nil {
+			return nil, errs.WithMessagef(err, "fail to scan %s", kind)
+		}
+		entries = append(entries, entry)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+func (br *BannerRepo) GetCatalogEntry(kind models.CatalogKind, id int) (*models.CatalogEntry, error) {
+	ctx, cancel := context.WithTimeout(br.Ctx, time.Second*5)
+	defer cancel()
+
+	table, err := catalogTable(kind)
+	if err != nil {
+		return nil, err
+	}
+
+	entry, err := scanCatalogEntry(br.data.Master().QueryRowContext(ctx,
+		`SELECT `+catalogColumns+` FROM `+table+` WHERE id = $1`, id))
+	if err != nil {
+		return nil, errs.WithMessagef(err, "fail to get %s %d", kind, id)
+	}
+
+	return entry, nil
+}
+
+// CreateCatalogEntry adds the entry, its ID is chosen by the caller as banners refer to it.
+func (br *BannerRepo) CreateCatalogEntry(kind models.CatalogKind, entry *models.CatalogEntry) error {
+	ctx, cancel := context.WithTimeout(br.Ctx, time.Second*5)
+	defer cancel()
+
+	table, err := catalogTable(kind)
+	if err != nil {
+		return err
+	}
+
+	err = br.data.Master().QueryRowContext(ctx,
+		`INSERT INTO `+table+` (id, name, description, archived, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, now(), now())
+		RETURNING created_at, updated_at`, entry.ID, entry.Name, entry.Description, entry.Archived).
+		Scan(&entry.CreatedAt, &entry.UpdatedAt)
+	var pqErr *pq.Error
+	if errs.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+		return errs.WithMessagef(ErrAlreadyExists, "%s %d", kind, entry.ID)
+	}
+	if err != nil {
+		return errs.WithMessagef(err, "fail to create %s %d", kind, entry.ID)
+	}
+
+	return nil
+}
+
+func (br *BannerRepo) UpdateCatalogEntry(kind models.CatalogKind, id int, patch models.CatalogPatch) (*models.CatalogEntry, error) {
+	ctx, cancel := context.WithTimeout(br.Ctx, time.Second*5)
+	defer cancel()
+
+	table, err := catalogTable(kind)
+	if err != nil {
+		return nil, err
+	}
+
+	entry, err := scanCatalogEntry(br.data.Master().QueryRowContext(ctx,
+		`UPDATE `+table+`
+		SET name = coalesce($2, name),
+			description = coalesce($3, description),
+			archived = coalesce($4, archived),
+			updated_at = now()
+		WHERE id = $1
+		RETURNING `+catalogColumns, id, patch.Name, patch.Description, patch.Archived))
+	if err != nil {
+		return nil, errs.WithMessagef(err, "fail to update %s %d", kind, id)
+	}
+
+	return entry, nil
+}
+
+// DeleteCatalogEntry removes an entry no banner version refers to, others can only be archived.
+func (br *BannerRepo) DeleteCatalogEntry(kind models.CatalogKind, id int) error {
+	ctx, cancel := context.WithTimeout(br.Ctx, time.Second*5)
+	defer cancel()
+
+	table, err := catalogTable(kind)
+	if err != nil {
+		return err
+	}
+
+	res, err := br.data.Master().ExecContext(ctx, `DELETE FROM `+table+` WHERE id = $1`, id)
+	var pqErr *pq.Error
+	if errs.As(err, &pqErr) && pqErr.Code == foreignKeyViolation {
+		return errs.WithMessagef(ErrInUse, "%s %d is used by banners", kind, id)
+	}
+	if err != nil {
+		return errs.WithMessagef(err, "fail to delete %s %d", kind, id)
+	}
+
+	if deleted, err := res.RowsAffected(); err == nil && deleted == 0 {
+		return errs.WithMessagef(sql.ErrNoRows, "%s %d not found", kind, id)
+	}
+
+	return nil
+}
@@ -0,0 +1,110 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/mashmorsik/banners-service/pkg/models"
+	errs "github.com/pkg/errors"
+)
+
+// eventBatchSize limits the rows added by one statement.
+const eventBatchSize = 1000
+
+// AddEventCounts adds the counts to the daily totals, counts of banners that don't exist
+// are skipped. The batches are written in one transaction, a failed call adds nothing and
+// the caller may retry all the counts. The context is given by the caller, so the last
+// counts can be written while the service is shutting down.
+func (br *BannerRepo) AddEventCounts(ctx context.Context, counts []models.EventCount) error {
+	tx, err := br.data.Master().BeginTx(ctx, nil)
+	if err != nil {
+		return errs.WithMessage(err, "can't begin transaction to add event counts")
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	for start := 0; start < len(counts); start += eventBatchSize {
+		batch := counts[start:min(start+eventBatchSize, len(counts))]
+
+		bannerIDs := make([]int64, len(batch))
+		versions := make([]int64, len(batch))
+		variants := make([]string, len(batch))
+		days := make([]string, len(batch))
+		impressions := make([]int64, len(batch))
+		clicks := make([]int64, len(batch))
+		for i, count := range batch {
+			bannerIDs[i] = int64(count.BannerID)
+			versions[i] = int64(count.Version)
+			variants[i] = count.Variant
+			days[i] = count.Day.Format(time.DateOnly)
+			impressions[i] = count.Impressions
+			clicks[i] = count.Clicks
+		}
+
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO banner_event_daily (banner_id, version, variant, day, impressions, clicks)
+			SELECT e.banner_id, e.version, e.variant, e.day, e.impressions, e.clicks
+			FROM unnest($1::int[], $2::int[], $3::text[], $4::date[], $5::bigint[], $6::bigint[])
+				AS e(banner_id, version, variant, day, impressions, clicks)
+			JOIN banner b ON b.id = e.banner_id
+			ON CONFLICT (banner_id, day, version, variant) DO UPDATE
+			SET impressions = banner_event_daily.impressions + EXCLUDED.impressions,
+				clicks = banner_event_daily.clicks + EXCLUDED.clicks`,
+			pq.Array(bannerIDs), pq.Array(versions), pq.Array(variants), pq.Array(days),
+			pq.Array(impressions), pq.Array(clicks))
+		if err != nil {
+			return errs.WithMessagef(err, "fail to add %d event counts", len(batch))
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return errs.WithMessagef(err, "fail to commit %d event counts", len(counts))
+	}
+
+	return nil
+}
+
+// GetEventStats returns the daily counts of the banner between the days, both included,
+// ordered by day, version and variant.
+func (br *BannerRepo) GetEventStats(bannerID int, from, to time.Time) ([]models.DailyStats, error) {
+	ctx, cancel := context.WithTimeout(br.Ctx, time.Second*5)
+	defer cancel()
+
+	var exists bool
+	err := br.data.Master().QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM banner WHERE id = $1)`, bannerID).Scan(&exists)
+	if err != nil {
+		return nil, errs.WithMessagef(err, "fail to check banner %d", bannerID)
+	}
+	if !exists {
+		return nil, errs.WithMessagef(sql.ErrNoRows, "banner %d not found", bannerID)
+	}
+
+	rows, err := br.data.Master().QueryContext(ctx,
+		`SELECT day, version, variant, impressions, clicks
+		FROM banner_event_daily
+		WHERE banner_id = $1
+		AND day BETWEEN $2::date AND $3::date
+		ORDER BY day, version, variant`, bannerID, from.Format(time.DateOnly), to.Format(time.DateOnly))
+	if err != nil {
+		return nil, errs.WithMessagef(err, "fail to get event stats of banner %d", bannerID)
+	}
+	defer func() { _ = rows.Close() }()
+
+	stats := make([]models.DailyStats, 0)
+	for rows.Next() {
+		var day time.Time
+		var row models.DailyStats
+		if err = rows.Scan(&day, &row.Version, &row.Variant, &row.Impressions, &row.Clicks); err != nil {
+			return nil, errs.WithMessagef(err, "fail to scan event stats of banner %d", bannerID)
+		}
+		row.Day = day.Format(time.DateOnly)
+		stats = append(stats, row)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
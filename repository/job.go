@@ -0,0 +1,90 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/mashmorsik/banners-service/pkg/models"
+	errs "github.com/pkg/errors"
+)
+
+// SaveJob stores the current state of the job, every instance reads jobs from the table.
+// The ctx is the caller's, the final state of a job is saved while the service shuts down.
+func (br *BannerRepo) SaveJob(ctx context.Context, j *models.Job) error {
+	_, err := br.data.Master().ExecContext(ctx,
+		`INSERT INTO job (id, type, status, affected, error, created_at, finished_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, now())
+		ON CONFLICT (id) DO UPDATE
+		SET status = excluded.status,
+			affected = excluded.affected,
+			error = excluded.error,
+			finished_at = excluded.finished_at,
+			updated_at = excluded.updated_at`,
+		j.ID, j.Type, j.Status, j.Affected, j.Error, j.CreatedAt, j.FinishedAt)
+	if err != nil {
+		return errs.WithMessagef(err, "fail to save job %s", j.ID)
+	}
+
+	return nil
+}
+
+func (br *BannerRepo) GetJob(id string) (*models.Job, error) {
+	ctx, cancel := context.WithTimeout(br.Ctx, time.Second*5)
+	defer cancel()
+
+	var j models.Job
+	err := br.data.Master().QueryRowContext(ctx,
+		`SELECT id, type, status, affected, error, created_at, finished_at
+		FROM job
+		WHERE id = $1`, id).Scan(&j.ID, &j.Type, &j.Status, &j.Affected, &j.Error, &j.CreatedAt, &j.FinishedAt)
+	if err != nil {
+		return nil, errs.WithMessagef(err, "fail to get job %s", id)
+	}
+
+	return &j, nil
+}
+
+// PurgeJobs removes jobs finished before the given time.
+func (br *BannerRepo) PurgeJobs(finishedBefore time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(br.Ctx, time.Second*5)
+	defer cancel()
+
+	res, err := br.data.Master().ExecContext(ctx,
+		`DELETE
+		FROM job
+		WHERE finished_at < $1`, finishedBefore)
+	if err != nil {
+		return 0, errs.WithMessagef(err, "fail to purge jobs finished before %s", finishedBefore)
+	}
+
+	purged, err := res.RowsAffected()
+	if err != nil {
+		return 0, errs.WithMessagef(err, "fail to get purged jobs count")
+	}
+
+	return int(purged), nil
+}
+
+// FailStaleJobs marks the unfinished jobs not saved since updatedBefore as failed, their
+// instance stopped before they finished.
+func (br *BannerRepo) FailStaleJobs(updatedBefore time.Time, reason string) (int, error) {
+	ctx, cancel := context.WithTimeout(br.Ctx, time.Second*5)
+	defer cancel()
+
+	res, err := br.data.Master().ExecContext(ctx,
+		`UPDATE job
+		SET status = $1, error = $2, finished_at = now(), updated_at = now()
+		WHERE status IN ($3, $4)
+		AND updated_at < $5`,
+		models.JobStatusFailed, reason, models.JobStatusPending, models.JobStatusRunning, updatedBefore)
+	if err != nil {
+		return 0, errs.WithMessagef(err, "fail to mark jobs not saved since %s failed", updatedBefore)
+	}
+
+	failed, err := res.RowsAffected()
+	if err != nil {
+		return 0, errs.WithMessagef(err, "fail to get failed jobs count")
+	}
+
+	return int(failed), nil
+}
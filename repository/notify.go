@@ -0,0 +1,42 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/mashmorsik/banners-service/infrastructure/data"
+	"github.com/mashmorsik/logger"
+)
+
+// notifyChanged announces changed banners to every instance listening on
+// data.BannerChangedChannel. The payload carries every feature/tag pair the banners were
+// stored with, it falls back to a flush request when the pairs exceed the notification
+// size limit. Notifications are best effort and sent after the write is committed.
+func (br *BannerRepo) notifyChanged(bannerIDs ...int) {
+	if len(bannerIDs) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(br.Ctx, time.Second*5)
+	defer cancel()
+
+	_, err := br.data.Master().ExecContext(ctx,
+		`SELECT pg_notify($1, CASE
+			WHEN length(n.payload) > 7900
+			THEN json_build_object('banner_ids', '[]'::json, 'pairs', '[]'::json, 'flush', true)::text
+			ELSE n.payload END)
+		FROM (
+			SELECT json_build_object(
+				'banner_ids', $2::int[],
+				'pairs', coalesce((
+					SELECT json_agg(json_build_object('feature_id', ft.feature_id, 'tag_id', ft.tag_id))
+					FROM (
+						SELECT DISTINCT feature_id, tag_id
+						FROM banner_feature_tag
+						WHERE banner_id = ANY($2)) ft), '[]'::json),
+				'flush', false)::text AS payload) n`, data.BannerChangedChannel, pq.Array(bannerIDs))
+	if err != nil {
+		logger.Errf("fail to notify about changed banners %v: %s", bannerIDs, err)
+	}
+}
@@ -0,0 +1,51 @@
+package repository
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// queryBuilder collects SQL conditions with their arguments. Values are only ever passed
+// as positional arguments, the SQL text is made of constants of the repository.
+type queryBuilder struct {
+	conditions []string
+	args       []any
+}
+
+// arg binds the value and returns its placeholder.
+func (q *queryBuilder) arg(value any) string {
+	q.args = append(q.args, value)
+	return "$" + strconv.Itoa(len(q.args))
+}
+
+// where adds a condition, every %s of the format is replaced by a placeholder of the
+// matching value.
+func (q *queryBuilder) where(format string, values ...any) {
+	placeholders := make([]any, len(values))
+	for i, value := range values {
+		placeholders[i] = q.arg(value)
+	}
+	q.conditions = append(q.conditions, fmt.Sprintf(format, placeholders...))
+}
+
+// and adds a condition without arguments of its own.
+func (q *queryBuilder) and(condition string) {
+	q.conditions = append(q.conditions, condition)
+}
+
+// take joins the collected conditions and starts a new group sharing the arguments, an
+// empty group matches every row.
+func (q *queryBuilder) take() string {
+	conditions := q.conditions
+	q.conditions = nil
+	if len(conditions) == 0 {
+		return "TRUE"
+	}
+	return strings.Join(conditions, "\n\tAND ")
+}
+
+// likePattern matches the text anywhere, escaping the LIKE wildcards it contains.
+func likePattern(text string) string {
+	return "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(text) + "%"
+}
@@ -0,0 +1,33 @@
+package repository
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestQueryBuilder(t *testing.T) {
+	q := &queryBuilder{}
+	q.where("f.feature_id = %s", 5)
+	q.where("(title ILIKE %[1]s OR text ILIKE %[1]s)", likePattern(`50%_off\`))
+	versionSQL := q.take()
+
+	q.and("b.deleted_at IS NULL")
+	q.where("b.is_active = %s", true)
+	bannerSQL := q.take()
+
+	wantVersionSQL := "f.feature_id = $1\n\tAND (title ILIKE $2 OR text ILIKE $2)"
+	if versionSQL != wantVersionSQL {
+		t.Errorf("version conditions got = %q, want %q", versionSQL, wantVersionSQL)
+	}
+	if wantBannerSQL := "b.deleted_at IS NULL\n\tAND b.is_active = $3"; bannerSQL != wantBannerSQL {
+		t.Errorf("banner conditions got = %q, want %q", bannerSQL, wantBannerSQL)
+	}
+	if got := q.take(); got != "TRUE" {
+		t.Errorf("empty conditions got = %q, want TRUE", got)
+	}
+
+	wantArgs := []any{5, `%50\%\_off\\%`, true}
+	if !reflect.DeepEqual(q.args, wantArgs) {
+		t.Errorf("args got = %v, want %v", q.args, wantArgs)
+	}
+}
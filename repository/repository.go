@@ -1,13 +1,41 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
 	"github.com/mashmorsik/banners-service/pkg/models"
+	errs "github.com/pkg/errors"
+	"time"
 )
 
+// ErrVersionMismatch is returned when a write expects another revision of the banner.
+var ErrVersionMismatch = errs.New("banner version mismatch")
+
+// ErrAlreadyExists is returned when a catalog entry with the same ID exists.
+var ErrAlreadyExists = errs.New("already exists")
+
+// ErrInvalidSchedule is returned when a banner would end before it starts.
+var ErrInvalidSchedule = errs.New("banner ends_at must be after starts_at")
+
+// ErrOverlap is returned when an active banner holds the feature and one of the tags in
+// an overlapping window.
+var ErrOverlap = errs.New("active banner with the feature and tag exists")
+
+// ErrInUse is returned when a catalog entry to delete is referenced by banners.
+var ErrInUse = errs.New("in use")
+
 type Repository interface {
 	GetForUser(b *models.Banner) (*models.Banner, error)
-	GetForAdmin(b *models.Banner, limit, offset int) ([]*models.Banner, error)
+	GetForAdmin(filter models.BannerFilter, page models.Page) (*models.BannerPage, error)
+	Search(text string, allVersions bool, page models.Page) (*models.SearchPage, error)
+	PutFeatureSchema(featureID int, schema json.RawMessage) (*models.FeatureSchema, error)
+	GetFeatureSchema(featureID, version int) (*models.FeatureSchema, error)
+	ListCatalog(kind models.CatalogKind, ids []int, includeArchived bool) ([]*models.CatalogEntry, error)
+	GetCatalogEntry(kind models.CatalogKind, id int) (*models.CatalogEntry, error)
+	CreateCatalogEntry(kind models.CatalogKind, entry *models.CatalogEntry) error
+	UpdateCatalogEntry(kind models.CatalogKind, id int, patch models.CatalogPatch) (*models.CatalogEntry, error)
+	DeleteCatalogEntry(kind models.CatalogKind, id int) error
 	CreateBanner(tx *sql.Tx, b *models.Banner) (int, error)
 	CreateContent(tx *sql.Tx, b *models.Banner) error
 	CreateFeatureTags(tx *sql.Tx, b *models.Banner) error
@@ -16,11 +44,28 @@ type Repository interface {
 	UpdateBanner(tx *sql.Tx, b *models.Banner, lastVersion int) error
 	UpdateFeatureTag(tx *sql.Tx, b *models.Banner) error
 	UpdateBannerContent(tx *sql.Tx, b *models.Banner) error
-	Update(b *models.Banner) error
+	Update(b *models.Banner, expectedRevision int, check func(merged *models.Banner) error) error
 	Delete(bannerID int) error
+	DeleteBatch(featureID, tagID, batchSize int) (int, []models.FeatureTag, error)
+	GetTrash(bannerID int) ([]*models.Banner, error)
+	Restore(bannerID int) error
+	PurgeDeleted(deletedBefore time.Time) (int, error)
 	CheckTagFeatureOverlap(b *models.Banner) (int, error)
 	GetBannerActiveVersions() (map[int]int, error)
-	SetVersionActive(bannerID, version int) error
-	AddNewTag(banner *models.Banner) error
-	AddNewFeature(banner *models.Banner) error
+	GetVersions(bannerID int) ([]*models.Banner, error)
+	GetVersion(bannerID, version int) (*models.Banner, error)
+	GetFeatureTags(bannerID int) ([]models.FeatureTag, error)
+	GetActiveContents(timeout time.Duration) ([]*models.Banner, error)
+	PruneVersions(bannerID, keepLast int, olderThan time.Time) (int, error)
+	SetVersionActive(bannerID, version, expectedRevision int) (int, error)
+	SetLocaleContent(bannerID int, locale string, content models.Content, expectedRevision int,
+		check func(featureID int) error) (int, error)
+	SaveJob(ctx context.Context, j *models.Job) error
+	GetJob(id string) (*models.Job, error)
+	PurgeJobs(finishedBefore time.Time) (int, error)
+	FailStaleJobs(updatedBefore time.Time, reason string) (int, error)
+	AddEventCounts(ctx context.Context, counts []models.EventCount) error
+	GetEventStats(bannerID int, from, to time.Time) ([]models.DailyStats, error)
+	AddNewTag(tx *sql.Tx, banner *models.Banner) error
+	AddNewFeature(tx *sql.Tx, banner *models.Banner) error
 }
@@ -0,0 +1,76 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/mashmorsik/banners-service/pkg/models"
+	errs "github.com/pkg/errors"
+)
+
+// PutFeatureSchema stores the schema as the next version for the feature, the feature is
+// added with an empty name when it is not known yet.
+func (br *BannerRepo) PutFeatureSchema(featureID int, schema json.RawMessage) (*models.FeatureSchema, error) {
+	ctx, cancel := context.WithTimeout(br.Ctx, time.Second*5)
+	defer cancel()
+
+	tx, err := br.data.Master().BeginTx(ctx, nil)
+	if err != nil {
+		return nil, errs.WithMessage(err, "can't begin transaction")
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO feature (id, name)
+		VALUES ($1, '')
+		ON CONFLICT (id) DO NOTHING`, featureID)
+	if err != nil {
+		return nil, errs.WithMessagef(err, "failed to add new feature with ID %d", featureID)
+	}
+
+	// serialize concurrent puts of the feature, so they don't take the same version
+	_, err = tx.ExecContext(ctx, `SELECT 1 FROM feature WHERE id = $1 FOR UPDATE`, featureID)
+	if err != nil {
+		return nil, errs.WithMessagef(err, "failed to lock feature with ID %d", featureID)
+	}
+
+	result := &models.FeatureSchema{FeatureID: featureID, Schema: schema}
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO feature_schema (feature_id, version, schema, created_at)
+		SELECT $1, coalesce(max(version), 0) + 1, $2, now()
+		FROM feature_schema
+		WHERE feature_id = $1
+		RETURNING version, created_at`, featureID, []byte(schema)).Scan(&result.Version, &result.CreatedAt)
+	if err != nil {
+		return nil, errs.WithMessagef(err, "fail to store schema for feature %d", featureID)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, errs.WithMessagef(err, "fail to commit schema for feature %d", featureID)
+	}
+
+	return result, nil
+}
+
+// GetFeatureSchema returns the given version of the feature schema, version 0 is the last one.
+func (br *BannerRepo) GetFeatureSchema(featureID, version int) (*models.FeatureSchema, error) {
+	ctx, cancel := context.WithTimeout(br.Ctx, time.Second*5)
+	defer cancel()
+
+	result := &models.FeatureSchema{}
+	var schema []byte
+	err := br.data.Master().QueryRowContext(ctx,
+		`SELECT feature_id, version, schema, created_at
+		FROM feature_schema
+		WHERE feature_id = $1
+		AND ($2 = 0 OR version = $2)
+		ORDER BY version DESC
+		LIMIT 1`, featureID, version).Scan(&result.FeatureID, &result.Version, &schema, &result.CreatedAt)
+	if err != nil {
+		return nil, errs.WithMessagef(err, "fail to get schema version %d for feature %d", version, featureID)
+	}
+	result.Schema = schema
+
+	return result, nil
+}
@@ -0,0 +1,134 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/mashmorsik/banners-service/pkg/models"
+	errs "github.com/pkg/errors"
+)
+
+// searchVector must match the expression of banner_content_search_idx, or the index is not used.
+// Title matches weigh more than text matches.
+const searchVector = `(setweight(to_tsvector('simple', coalesce(bc.content ->> 'title', '')), 'A') ||
+	setweight(to_tsvector('simple', coalesce(bc.content ->> 'text', '')), 'B'))`
+
+// escapeHTML escapes the content before it is highlighted, so the snippet is safe to render as
+// HTML and the only tags in it are the <mark> tags around the matches.
+func escapeHTML(expr string) string {
+	return `replace(replace(replace(replace(` + expr + `, '&', '&amp;'), '<', '&lt;'), '>', '&gt;'), '"', '&quot;')`
+}
+
+// searchHeadline keeps the whole title and up to two fragments of the text around the matches.
+const searchHeadline = `'StartSel=<mark>, StopSel=</mark>, HighlightAll=true'`
+const searchTextHeadline = `'StartSel=<mark>, StopSel=</mark>, MaxFragments=2, MaxWords=20, MinWords=5'`
+
+// Search finds banners by the title and text of their active version, or of any version when
+// allVersions is set, then the best ranked version of a banner is returned. The text uses the
+// web search syntax: quoted phrases, "or" and a leading "-" to exclude a word.
+func (br *BannerRepo) Search(text string, allVersions bool, page models.Page) (*models.SearchPage, error) {
+	ctx, cancel := context.WithTimeout(br.Ctx, time.Second*5)
+	defer cancel()
+
+	q := &queryBuilder{}
+	tsQuery := "websearch_to_tsquery('simple', " + q.arg(text) + ")"
+
+	q.and("b.deleted_at IS NULL")
+	q.and(searchVector + " @@ " + tsQuery)
+	if !allVersions {
+		q.and("bc.version = b.active_version")
+	}
+	hitSQL := q.take()
+
+	hits := `
+	WITH hits AS (
+		SELECT DISTINCT ON (b.id) b.id, bc.version, ts_rank(` + searchVector + `, ` + tsQuery + `)::float8 AS rank
+		FROM banner b
+		JOIN banner_content bc ON bc.banner_id = b.id
+		WHERE ` + hitSQL + `
+		ORDER BY b.id, rank DESC, bc.version DESC)`
+
+	result := &models.SearchPage{Hits: []*models.SearchHit{}}
+	err := br.data.Master().QueryRowContext(ctx, hits+`
+	SELECT count(*) FROM hits`, q.args...).Scan(&result.Total)
+	if err != nil {
+		return nil, errs.WithMessage(err, "fail to count search hits")
+	}
+
+	if c := page.Cursor; c != nil {
+		q.where("(rank, id) < (%s::float8, %s::int)", c.Rank, c.ID)
+	}
+	pageSQL := q.take()
+
+	// one banner past the limit tells whether there is a next page
+	var queryLimit, queryOffset interface{}
+	if page.Limit != 0 {
+		queryLimit = page.Limit + 1
+	}
+	if page.Offset != 0 {
+		queryOffset = page.Offset
+	}
+
+	rows, err := br.data.Master().QueryContext(ctx, hits+`, page AS (
+		SELECT id, version, rank
+		FROM hits
+		WHERE `+pageSQL+`
+		ORDER BY rank DESC, id DESC
+		LIMIT `+q.arg(queryLimit)+` OFFSET `+q.arg(queryOffset)+`)
+	SELECT
+		p.rank,
+		b.id,
+		p.version,
+		b.created_at,
+		b.updated_at,
+		b.starts_at,
+		b.ends_at,
+		p.version = b.active_version,
+		bft.tag_id,
+		bft.feature_id,
+		bc.content,
+		ts_headline('simple', `+escapeHTML("coalesce(bc.content ->> 'title', '')")+`, `+tsQuery+`, `+searchHeadline+`),
+		ts_headline('simple', `+escapeHTML("coalesce(bc.content ->> 'text', '')")+`, `+tsQuery+`, `+searchTextHeadline+`)
+	FROM page p
+	JOIN banner b ON b.id = p.id
+	JOIN banner_content bc ON bc.banner_id = p.id AND bc.version = p.version
+	JOIN banner_feature_tag bft ON bft.banner_id = p.id AND bft.version = p.version
+	ORDER BY p.rank DESC, p.id DESC, bft.tag_id`, q.args...)
+	if err != nil {
+		return nil, errs.WithMessage(err, "fail to search banners")
+	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		var hit models.SearchHit
+		var contentJSON []byte
+		var tag int
+		if err = rows.Scan(&hit.Rank, &hit.ID, &hit.Version, &hit.CreatedAt, &hit.UpdatedAt, &hit.StartsAt,
+			&hit.EndsAt, &hit.IsActive, &tag, &hit.FeatureID, &contentJSON,
+			&hit.Snippet.Title, &hit.Snippet.Text); err != nil {
+			return nil, err
+		}
+
+		if last := len(result.Hits) - 1; last >= 0 && result.Hits[last].ID == hit.ID {
+			result.Hits[last].TagIDs = append(result.Hits[last].TagIDs, tag)
+			continue
+		}
+		if err = json.Unmarshal(contentJSON, &hit.Content); err != nil {
+			return nil, err
+		}
+		hit.TagIDs = []int{tag}
+		result.Hits = append(result.Hits, &hit)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if page.Limit != 0 && len(result.Hits) > page.Limit {
+		result.Hits = result.Hits[:page.Limit]
+		last := result.Hits[len(result.Hits)-1]
+		result.NextCursor = &models.Cursor{Sort: models.SortByRank, Desc: true, Rank: last.Rank, ID: last.ID}
+	}
+
+	return result, nil
+}
@@ -5,8 +5,11 @@
 package mock_repository
 
 import (
+	context "context"
 	sql "database/sql"
+	json "encoding/json"
 	reflect "reflect"
+	time "time"
 
 	gomock "github.com/golang/mock/gomock"
 	models "github.com/mashmorsik/banners-service/pkg/models"
@@ -35,32 +38,46 @@ func (m *MockRepository) EXPECT() *MockRepositoryMockRecorder {
 	return m.recorder
 }
 
+// AddEventCounts mocks base method.
+func (m *MockRepository) AddEventCounts(ctx context.Context, counts []models.EventCount) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AddEventCounts", ctx, counts)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AddEventCounts indicates an expected call of AddEventCounts.
+func (mr *MockRepositoryMockRecorder) AddEventCounts(ctx, counts interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddEventCounts", reflect.TypeOf((*MockRepository)(nil).AddEventCounts), ctx, counts)
+}
+
 // AddNewFeature mocks base method.
-func (m *MockRepository) AddNewFeature(banner *models.Banner) error {
+func (m *MockRepository) AddNewFeature(tx *sql.Tx, banner *models.Banner) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "AddNewFeature", banner)
+	ret := m.ctrl.Call(m, "AddNewFeature", tx, banner)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // AddNewFeature indicates an expected call of AddNewFeature.
-func (mr *MockRepositoryMockRecorder) AddNewFeature(banner interface{}) *gomock.Call {
+func (mr *MockRepositoryMockRecorder) AddNewFeature(tx, banner interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddNewFeature", reflect.TypeOf((*MockRepository)(nil).AddNewFeature), banner)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddNewFeature", reflect.TypeOf((*MockRepository)(nil).AddNewFeature), tx, banner)
 }
 
 // AddNewTag mocks base method.
-func (m *MockRepository) AddNewTag(banner *models.Banner) error {
+func (m *MockRepository) AddNewTag(tx *sql.Tx, banner *models.Banner) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "AddNewTag", banner)
+	ret := m.ctrl.Call(m, "AddNewTag", tx, banner)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // AddNewTag indicates an expected call of AddNewTag.
-func (mr *MockRepositoryMockRecorder) AddNewTag(banner interface{}) *gomock.Call {
+func (mr *MockRepositoryMockRecorder) AddNewTag(tx, banner interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddNewTag", reflect.TypeOf((*MockRepository)(nil).AddNewTag), banner)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddNewTag", reflect.TypeOf((*MockRepository)(nil).AddNewTag), tx, banner)
 }
 
 // CheckTagFeatureOverlap mocks base method.
@@ -107,6 +124,20 @@ func (mr *MockRepositoryMockRecorder) CreateBanner(tx, b interface{}) *gomock.Ca
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateBanner", reflect.TypeOf((*MockRepository)(nil).CreateBanner), tx, b)
 }
 
+// CreateCatalogEntry mocks base method.
+func (m *MockRepository) CreateCatalogEntry(kind models.CatalogKind, entry *models.CatalogEntry) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateCatalogEntry", kind, entry)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateCatalogEntry indicates an expected call of CreateCatalogEntry.
+func (mr *MockRepositoryMockRecorder) CreateCatalogEntry(kind, entry interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateCatalogEntry", reflect.TypeOf((*MockRepository)(nil).CreateCatalogEntry), kind, entry)
+}
+
 // CreateContent mocks base method.
 func (m *MockRepository) CreateContent(tx *sql.Tx, b *models.Banner) error {
 	m.ctrl.T.Helper()
@@ -149,6 +180,66 @@ func (mr *MockRepositoryMockRecorder) Delete(bannerID interface{}) *gomock.Call
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockRepository)(nil).Delete), bannerID)
 }
 
+// DeleteBatch mocks base method.
+func (m *MockRepository) DeleteBatch(featureID, tagID, batchSize int) (int, []models.FeatureTag, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteBatch", featureID, tagID, batchSize)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].([]models.FeatureTag)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// DeleteBatch indicates an expected call of DeleteBatch.
+func (mr *MockRepositoryMockRecorder) DeleteBatch(featureID, tagID, batchSize interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteBatch", reflect.TypeOf((*MockRepository)(nil).DeleteBatch), featureID, tagID, batchSize)
+}
+
+// DeleteCatalogEntry mocks base method.
+func (m *MockRepository) DeleteCatalogEntry(kind models.CatalogKind, id int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteCatalogEntry", kind, id)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteCatalogEntry indicates an expected call of DeleteCatalogEntry.
+func (mr *MockRepositoryMockRecorder) DeleteCatalogEntry(kind, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteCatalogEntry", reflect.TypeOf((*MockRepository)(nil).DeleteCatalogEntry), kind, id)
+}
+
+// FailStaleJobs mocks base method.
+func (m *MockRepository) FailStaleJobs(updatedBefore time.Time, reason string) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FailStaleJobs", updatedBefore, reason)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// FailStaleJobs indicates an expected call of FailStaleJobs.
+func (mr *MockRepositoryMockRecorder) FailStaleJobs(updatedBefore, reason interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FailStaleJobs", reflect.TypeOf((*MockRepository)(nil).FailStaleJobs), updatedBefore, reason)
+}
+
+// GetActiveContents mocks base method.
+func (m *MockRepository) GetActiveContents(timeout time.Duration) ([]*models.Banner, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetActiveContents", timeout)
+	ret0, _ := ret[0].([]*models.Banner)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetActiveContents indicates an expected call of GetActiveContents.
+func (mr *MockRepositoryMockRecorder) GetActiveContents(timeout interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetActiveContents", reflect.TypeOf((*MockRepository)(nil).GetActiveContents), timeout)
+}
+
 // GetBannerActiveVersions mocks base method.
 func (m *MockRepository) GetBannerActiveVersions() (map[int]int, error) {
 	m.ctrl.T.Helper()
@@ -164,19 +255,79 @@ func (mr *MockRepositoryMockRecorder) GetBannerActiveVersions() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetBannerActiveVersions", reflect.TypeOf((*MockRepository)(nil).GetBannerActiveVersions))
 }
 
+// GetCatalogEntry mocks base method.
+func (m *MockRepository) GetCatalogEntry(kind models.CatalogKind, id int) (*models.CatalogEntry, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetCatalogEntry", kind, id)
+	ret0, _ := ret[0].(*models.CatalogEntry)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetCatalogEntry indicates an expected call of GetCatalogEntry.
+func (mr *MockRepositoryMockRecorder) GetCatalogEntry(kind, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCatalogEntry", reflect.TypeOf((*MockRepository)(nil).GetCatalogEntry), kind, id)
+}
+
+// GetEventStats mocks base method.
+func (m *MockRepository) GetEventStats(bannerID int, from, to time.Time) ([]models.DailyStats, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetEventStats", bannerID, from, to)
+	ret0, _ := ret[0].([]models.DailyStats)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetEventStats indicates an expected call of GetEventStats.
+func (mr *MockRepositoryMockRecorder) GetEventStats(bannerID, from, to interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetEventStats", reflect.TypeOf((*MockRepository)(nil).GetEventStats), bannerID, from, to)
+}
+
+// GetFeatureSchema mocks base method.
+func (m *MockRepository) GetFeatureSchema(featureID, version int) (*models.FeatureSchema, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetFeatureSchema", featureID, version)
+	ret0, _ := ret[0].(*models.FeatureSchema)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetFeatureSchema indicates an expected call of GetFeatureSchema.
+func (mr *MockRepositoryMockRecorder) GetFeatureSchema(featureID, version interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetFeatureSchema", reflect.TypeOf((*MockRepository)(nil).GetFeatureSchema), featureID, version)
+}
+
+// GetFeatureTags mocks base method.
+func (m *MockRepository) GetFeatureTags(bannerID int) ([]models.FeatureTag, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetFeatureTags", bannerID)
+	ret0, _ := ret[0].([]models.FeatureTag)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetFeatureTags indicates an expected call of GetFeatureTags.
+func (mr *MockRepositoryMockRecorder) GetFeatureTags(bannerID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetFeatureTags", reflect.TypeOf((*MockRepository)(nil).GetFeatureTags), bannerID)
+}
+
 // GetForAdmin mocks base method.
-func (m *MockRepository) GetForAdmin(b *models.Banner, limit, offset int) ([]*models.Banner, error) {
+func (m *MockRepository) GetForAdmin(filter models.BannerFilter, page models.Page) (*models.BannerPage, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetForAdmin", b, limit, offset)
-	ret0, _ := ret[0].([]*models.Banner)
+	ret := m.ctrl.Call(m, "GetForAdmin", filter, page)
+	ret0, _ := ret[0].(*models.BannerPage)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetForAdmin indicates an expected call of GetForAdmin.
-func (mr *MockRepositoryMockRecorder) GetForAdmin(b, limit, offset interface{}) *gomock.Call {
+func (mr *MockRepositoryMockRecorder) GetForAdmin(filter, page interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetForAdmin", reflect.TypeOf((*MockRepository)(nil).GetForAdmin), b, limit, offset)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetForAdmin", reflect.TypeOf((*MockRepository)(nil).GetForAdmin), filter, page)
 }
 
 // GetForUser mocks base method.
@@ -194,6 +345,81 @@ func (mr *MockRepositoryMockRecorder) GetForUser(b interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetForUser", reflect.TypeOf((*MockRepository)(nil).GetForUser), b)
 }
 
+// GetJob mocks base method.
+func (m *MockRepository) GetJob(id string) (*models.Job, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetJob", id)
+	ret0, _ := ret[0].(*models.Job)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetJob indicates an expected call of GetJob.
+func (mr *MockRepositoryMockRecorder) GetJob(id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetJob", reflect.TypeOf((*MockRepository)(nil).GetJob), id)
+}
+
+// GetTrash mocks base method.
+func (m *MockRepository) GetTrash(bannerID int) ([]*models.Banner, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetTrash", bannerID)
+	ret0, _ := ret[0].([]*models.Banner)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetTrash indicates an expected call of GetTrash.
+func (mr *MockRepositoryMockRecorder) GetTrash(bannerID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetTrash", reflect.TypeOf((*MockRepository)(nil).GetTrash), bannerID)
+}
+
+// GetVersion mocks base method.
+func (m *MockRepository) GetVersion(bannerID, version int) (*models.Banner, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetVersion", bannerID, version)
+	ret0, _ := ret[0].(*models.Banner)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetVersion indicates an expected call of GetVersion.
+func (mr *MockRepositoryMockRecorder) GetVersion(bannerID, version interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetVersion", reflect.TypeOf((*MockRepository)(nil).GetVersion), bannerID, version)
+}
+
+// GetVersions mocks base method.
+func (m *MockRepository) GetVersions(bannerID int) ([]*models.Banner, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetVersions", bannerID)
+	ret0, _ := ret[0].([]*models.Banner)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetVersions indicates an expected call of GetVersions.
+func (mr *MockRepositoryMockRecorder) GetVersions(bannerID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetVersions", reflect.TypeOf((*MockRepository)(nil).GetVersions), bannerID)
+}
+
+// ListCatalog mocks base method.
+func (m *MockRepository) ListCatalog(kind models.CatalogKind, ids []int, includeArchived bool) ([]*models.CatalogEntry, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListCatalog", kind, ids, includeArchived)
+	ret0, _ := ret[0].([]*models.CatalogEntry)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListCatalog indicates an expected call of ListCatalog.
+func (mr *MockRepositoryMockRecorder) ListCatalog(kind, ids, includeArchived interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListCatalog", reflect.TypeOf((*MockRepository)(nil).ListCatalog), kind, ids, includeArchived)
+}
+
 // MergeUpdateVersion mocks base method.
 func (m *MockRepository) MergeUpdateVersion(tx *sql.Tx, b *models.Banner, lastVersion int) (*models.Banner, error) {
 	m.ctrl.T.Helper()
@@ -209,32 +435,151 @@ func (mr *MockRepositoryMockRecorder) MergeUpdateVersion(tx, b, lastVersion inte
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MergeUpdateVersion", reflect.TypeOf((*MockRepository)(nil).MergeUpdateVersion), tx, b, lastVersion)
 }
 
-// SetVersionActive mocks base method.
-func (m *MockRepository) SetVersionActive(bannerID, version int) error {
+// PruneVersions mocks base method.
+func (m *MockRepository) PruneVersions(bannerID, keepLast int, olderThan time.Time) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PruneVersions", bannerID, keepLast, olderThan)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// PruneVersions indicates an expected call of PruneVersions.
+func (mr *MockRepositoryMockRecorder) PruneVersions(bannerID, keepLast, olderThan interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PruneVersions", reflect.TypeOf((*MockRepository)(nil).PruneVersions), bannerID, keepLast, olderThan)
+}
+
+// PurgeDeleted mocks base method.
+func (m *MockRepository) PurgeDeleted(deletedBefore time.Time) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PurgeDeleted", deletedBefore)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// PurgeDeleted indicates an expected call of PurgeDeleted.
+func (mr *MockRepositoryMockRecorder) PurgeDeleted(deletedBefore interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PurgeDeleted", reflect.TypeOf((*MockRepository)(nil).PurgeDeleted), deletedBefore)
+}
+
+// PurgeJobs mocks base method.
+func (m *MockRepository) PurgeJobs(finishedBefore time.Time) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PurgeJobs", finishedBefore)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// PurgeJobs indicates an expected call of PurgeJobs.
+func (mr *MockRepositoryMockRecorder) PurgeJobs(finishedBefore interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PurgeJobs", reflect.TypeOf((*MockRepository)(nil).PurgeJobs), finishedBefore)
+}
+
+// PutFeatureSchema mocks base method.
+func (m *MockRepository) PutFeatureSchema(featureID int, schema json.RawMessage) (*models.FeatureSchema, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "SetVersionActive", bannerID, version)
+	ret := m.ctrl.Call(m, "PutFeatureSchema", featureID, schema)
+	ret0, _ := ret[0].(*models.FeatureSchema)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// PutFeatureSchema indicates an expected call of PutFeatureSchema.
+func (mr *MockRepositoryMockRecorder) PutFeatureSchema(featureID, schema interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PutFeatureSchema", reflect.TypeOf((*MockRepository)(nil).PutFeatureSchema), featureID, schema)
+}
+
+// Restore mocks base method.
+func (m *MockRepository) Restore(bannerID int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Restore", bannerID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Restore indicates an expected call of Restore.
+func (mr *MockRepositoryMockRecorder) Restore(bannerID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Restore", reflect.TypeOf((*MockRepository)(nil).Restore), bannerID)
+}
+
+// SaveJob mocks base method.
+func (m *MockRepository) SaveJob(ctx context.Context, j *models.Job) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveJob", ctx, j)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
+// SaveJob indicates an expected call of SaveJob.
+func (mr *MockRepositoryMockRecorder) SaveJob(ctx, j interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveJob", reflect.TypeOf((*MockRepository)(nil).SaveJob), ctx, j)
+}
+
+// Search mocks base method.
+func (m *MockRepository) Search(text string, allVersions bool, page models.Page) (*models.SearchPage, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Search", text, allVersions, page)
+	ret0, _ := ret[0].(*models.SearchPage)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Search indicates an expected call of Search.
+func (mr *MockRepositoryMockRecorder) Search(text, allVersions, page interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Search", reflect.TypeOf((*MockRepository)(nil).Search), text, allVersions, page)
+}
+
+// SetLocaleContent mocks base method.
+func (m *MockRepository) SetLocaleContent(bannerID int, locale string, content models.Content, expectedRevision int, check func(int) error) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetLocaleContent", bannerID, locale, content, expectedRevision, check)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SetLocaleContent indicates an expected call of SetLocaleContent.
+func (mr *MockRepositoryMockRecorder) SetLocaleContent(bannerID, locale, content, expectedRevision, check interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetLocaleContent", reflect.TypeOf((*MockRepository)(nil).SetLocaleContent), bannerID, locale, content, expectedRevision, check)
+}
+
+// SetVersionActive mocks base method.
+func (m *MockRepository) SetVersionActive(bannerID, version, expectedRevision int) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetVersionActive", bannerID, version, expectedRevision)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
 // SetVersionActive indicates an expected call of SetVersionActive.
-func (mr *MockRepositoryMockRecorder) SetVersionActive(bannerID, version interface{}) *gomock.Call {
+func (mr *MockRepositoryMockRecorder) SetVersionActive(bannerID, version, expectedRevision interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetVersionActive", reflect.TypeOf((*MockRepository)(nil).SetVersionActive), bannerID, version)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetVersionActive", reflect.TypeOf((*MockRepository)(nil).SetVersionActive), bannerID, version, expectedRevision)
 }
 
 // Update mocks base method.
-func (m *MockRepository) Update(b *models.Banner) error {
+func (m *MockRepository) Update(b *models.Banner, expectedRevision int, check func(*models.Banner) error) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Update", b)
+	ret := m.ctrl.Call(m, "Update", b, expectedRevision, check)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // Update indicates an expected call of Update.
-func (mr *MockRepositoryMockRecorder) Update(b interface{}) *gomock.Call {
+func (mr *MockRepositoryMockRecorder) Update(b, expectedRevision, check interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Update", reflect.TypeOf((*MockRepository)(nil).Update), b)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Update", reflect.TypeOf((*MockRepository)(nil).Update), b, expectedRevision, check)
 }
 
 // UpdateBanner mocks base method.
@@ -265,6 +610,21 @@ func (mr *MockRepositoryMockRecorder) UpdateBannerContent(tx, b interface{}) *go
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateBannerContent", reflect.TypeOf((*MockRepository)(nil).UpdateBannerContent), tx, b)
 }
 
+// UpdateCatalogEntry mocks base method.
+func (m *MockRepository) UpdateCatalogEntry(kind models.CatalogKind, id int, patch models.CatalogPatch) (*models.CatalogEntry, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateCatalogEntry", kind, id, patch)
+	ret0, _ := ret[0].(*models.CatalogEntry)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UpdateCatalogEntry indicates an expected call of UpdateCatalogEntry.
+func (mr *MockRepositoryMockRecorder) UpdateCatalogEntry(kind, id, patch interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateCatalogEntry", reflect.TypeOf((*MockRepository)(nil).UpdateCatalogEntry), kind, id, patch)
+}
+
 // UpdateFeatureTag mocks base method.
 func (m *MockRepository) UpdateFeatureTag(tx *sql.Tx, b *models.Banner) error {
 	m.ctrl.T.Helper()